@@ -4,13 +4,14 @@
 package abm
 
 import (
+	"errors"
 	"fmt"
 	"golang.org/x/exp/constraints"
+	"io"
 	"math"
 	"math/rand"
 	"os"
 	"slices"
-	"strconv"
 	"strings"
 )
 
@@ -26,102 +27,247 @@ type Parameters struct {
 	MutationRate float64
 	Compatible   bool
 	Analysis     string
+	// Agents carry two copies of each gene, one from each parent
+	Diploid bool
+	// Compute ancestry for each generation as it is created
+	TrackAncestry bool
+	// Number of goroutines for pairwise analyses, 0 for one per CPU
+	Workers int
+	// Number of random pairs used to estimate pairwise statistics, 0 for all pairs
+	PairSample int
+	// Simplify the pedigree every this many generations, 0 for never
+	SimplifyInterval int
+	// Number of chromosomes the genes are spread across, 0 for unlinked genes
+	Chromosomes int
+	// Genetic map length of each chromosome in Morgans
+	ChromosomeLength float64
+	// Mutation rate of Y chromosome and mitochondrial lineages
+	UniparentalMutationRate float64
+	// Parent surnames are inherited from, "father" or "mother", or empty for no surnames
+	Surnames string
+	// How mutations change genes: infinite-alleles, infinite-sites or stepwise
+	MutationModel string
+	// Mutation rates of the first loci, overriding MutationRate
+	LocusMutationRates []float64
+	// Proportion of founder genes carrying the focal variant
+	VariantFrequency float64
+	// Number of loci, starting from the first, where the variant affects fitness
+	SelectedLoci int
+	// Change in fitness of agents homozygous for the variant at a selected locus
+	SelectionCoefficient float64
+	// Dominance of the variant: additive, dominant or recessive
+	Dominance string
+	// Shortest segment in Morgans counted as shared identical by descent
+	IBDMinLength float64
+	// Quantiles reported for ancestor statistics
+	Quantiles []float64
+	// Number of histogram bins reported for ancestor statistics
+	HistogramBins int
 }
 
 // Sets the default values for the parameters
 func NewParameters() Parameters {
 	return Parameters{
-		SimulationId: 0,
-		NumAgents:    100,
-		Generations:  4,
-		GrowthRate:   1.01,
-		Monogamous:   true,
-		MatingK:      50,
-		NumGenes:     10,
-		MutationRate: 0.0,
-		Compatible:   true,
-		Analysis:     "NCDG",
+		SimulationId:            0,
+		NumAgents:               100,
+		Generations:             4,
+		GrowthRate:              1.01,
+		Monogamous:              true,
+		MatingK:                 50,
+		NumGenes:                10,
+		MutationRate:            0.0,
+		Compatible:              true,
+		Analysis:                "NCDG",
+		Diploid:                 false,
+		TrackAncestry:           false,
+		Workers:                 0,
+		PairSample:              0,
+		SimplifyInterval:        0,
+		Chromosomes:             0,
+		ChromosomeLength:        1.0,
+		UniparentalMutationRate: 0.0,
+		Surnames:                "",
+		MutationModel:           INFINITE_ALLELES,
+		LocusMutationRates:      nil,
+		VariantFrequency:        0.0,
+		SelectedLoci:            0,
+		SelectionCoefficient:    0.0,
+		Dominance:               ADDITIVE,
+		IBDMinLength:            0.0,
+		Quantiles:               []float64{0.05, 0.25, 0.5, 0.75, 0.95},
+		HistogramBins:           10,
 	}
 }
 
+// Letters of the analyses that can be run
+const analysisLetters = "NCDGSAUFMTHI"
+
+// Checks that the parameters make sense, returning an error that describes
+// every problem found, or nil if there are none. Empty strings select the
+// default mutation model and dominance.
+func (p *Parameters) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	probability := func(name string, value float64) {
+		check(value >= 0.0 && value <= 1.0, "%s must be from 0 to 1, not %v", name, value)
+	}
+	check(p.NumAgents > 0, "NumAgents must be positive, not %d", p.NumAgents)
+	check(p.Generations >= 0, "Generations can't be negative, not %d", p.Generations)
+	check(p.GrowthRate > 0.0, "GrowthRate must be positive, not %v", p.GrowthRate)
+	check(p.MatingK >= 2, "MatingK must be at least 2 for agents to find partners, not %d", p.MatingK)
+	check(p.NumGenes >= 0, "NumGenes can't be negative, not %d", p.NumGenes)
+	probability("MutationRate", p.MutationRate)
+	check(p.SimplifyInterval >= 0, "SimplifyInterval can't be negative, not %d", p.SimplifyInterval)
+	check(p.Chromosomes >= 0, "Chromosomes can't be negative, not %d", p.Chromosomes)
+	check(p.Chromosomes == 0 || p.ChromosomeLength > 0.0,
+		"ChromosomeLength must be positive, not %v", p.ChromosomeLength)
+	probability("UniparentalMutationRate", p.UniparentalMutationRate)
+	check(slices.Contains([]string{"", "father", "mother"}, p.Surnames),
+		"Surnames must be father, mother or empty, not %q", p.Surnames)
+	check(slices.Contains([]string{"", INFINITE_ALLELES, INFINITE_SITES, STEPWISE}, p.MutationModel),
+		"MutationModel must be %s, %s or %s, not %q", INFINITE_ALLELES, INFINITE_SITES, STEPWISE, p.MutationModel)
+	check(len(p.LocusMutationRates) <= max(p.NumGenes, 0),
+		"LocusMutationRates has %d rates but there are only %d genes", len(p.LocusMutationRates), p.NumGenes)
+	for _, rate := range p.LocusMutationRates {
+		probability("LocusMutationRates", rate)
+	}
+	probability("VariantFrequency", p.VariantFrequency)
+	check(p.SelectedLoci >= 0 && p.SelectedLoci <= max(p.NumGenes, 0),
+		"SelectedLoci must be from 0 to the %d genes, not %d", p.NumGenes, p.SelectedLoci)
+	check(p.SelectionCoefficient >= -1.0, "SelectionCoefficient can't be below -1, not %v", p.SelectionCoefficient)
+	check(slices.Contains([]string{"", ADDITIVE, DOMINANT, RECESSIVE}, p.Dominance),
+		"Dominance must be %s, %s or %s, not %q", ADDITIVE, DOMINANT, RECESSIVE, p.Dominance)
+	errs = append(errs, p.ValidateAnalysis())
+	return errors.Join(errs...)
+}
+
+// Replaces an empty mutation model or dominance with its default, so the
+// parameters a simulation reports are the ones it uses
+func (p *Parameters) setDefaults() {
+	if p.MutationModel == "" {
+		p.MutationModel = INFINITE_ALLELES
+	}
+	if p.Dominance == "" {
+		p.Dominance = ADDITIVE
+	}
+}
+
+// Returns an error describing every invalid setting used by the analyses,
+// which are the only settings used when analysing an imported pedigree
+func (p *Parameters) ValidateAnalysis() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	for _, letter := range p.Analysis {
+		check(strings.ContainsRune(analysisLetters, letter),
+			"Analysis has unknown letter %q, letters are %s", letter, analysisLetters)
+	}
+	check(p.Workers >= 0, "Workers can't be negative, not %d", p.Workers)
+	check(p.PairSample >= 0, "PairSample can't be negative, not %d", p.PairSample)
+	check(p.IBDMinLength >= 0.0, "IBDMinLength can't be negative, not %v", p.IBDMinLength)
+	for _, q := range p.Quantiles {
+		check(q >= 0.0 && q <= 1.0, "Quantiles must be from 0 to 1, not %v", q)
+	}
+	check(p.HistogramBins >= 0, "HistogramBins can't be negative, not %d", p.HistogramBins)
+	return errors.Join(errs...)
+}
+
 type Sex int
 
 const (
 	MALE   Sex = 0
 	FEMALE Sex = 1
+	// The sex of an individual in an imported pedigree that isn't recorded
+	// and can't be inferred from being a parent
+	UNKNOWN Sex = 2
 )
 
+// Returns the letter pedigree files use for a sex: M, F or U
+func (sex Sex) letter() string {
+	switch sex {
+	case MALE:
+		return "M"
+	case FEMALE:
+		return "F"
+	}
+	return "U"
+}
+
+// The id recorded for a parent that isn't known, such as the parents of a
+// founder or a missing parent in an imported pedigree
+const Unknown = -1
+
 // Data structure for each individual in the simulation.
-// We keep both an array and set of ancestors because sometimes
-// one is more efficient to use than the other.
-// Genes are of the form [0-9]+\-[0-9]+`*
-// The first integer is the agent id. The second is the number of the gene.
-// Each backtick represents a mutation.
+// The mother and father are Unknown if they aren't known.
+// Ancestors are kept in a bitset indexed by agent id because it is
+// compact and intersecting two sets is fast. Unless ancestry is tracked,
+// an agent's set is released once its children's sets are built, and only
+// the number of ancestors is kept.
+// Genes are stored by locus with the copies of a locus next to each
+// other, and origins records which parent and which of its copies each
+// gene came from. Chromosomes are stored the same way and record the
+// founder segments each chromosome copy is made of. The Y lineage is
+// passed from father to son and the mitochondrial lineage from mother to
+// child. Surnames are numbered by the founder who first had them.
 type Agent struct {
-	id          int
-	generation  int
-	sex         Sex
-	mother      int
-	father      int
-	children    []int
-	ancestorVec []int
-	ancestorSet map[int]struct{}
-	genes       []string
+	id           int
+	generation   int
+	sex          Sex
+	mother       int
+	father       int
+	children     []int
+	ancestors    bitset
+	numAncestors int
+	genes        []Gene
+	origins      []origin
+	chromosomes  [][]Segment
+	yLineage     Haplogroup
+	mtLineage    Haplogroup
+	surname      int32
 }
 
 // Checks if two agents share a mother or father in which case they are siblings.
 func isSibling(a, b *Agent) bool {
-	if a.generation == 0 {
-		return false
-	}
-	return a.mother == b.mother || a.father == b.father
+	return (a.mother != Unknown && a.mother == b.mother) ||
+		(a.father != Unknown && a.father == b.father)
 }
 
 // Check if two agents share a grandparent in which case they are cousins.
 func isCousin(agents []Agent, a, b *Agent) bool {
-	if a.generation < 2 || b.generation < 2 {
-		return false
-	}
-	aMother := agents[a.mother]
-	aFather := agents[a.father]
-	bMother := agents[b.mother]
-	bFather := agents[b.father]
-
-	return isSibling(&aMother, &bMother) || isSibling(&aMother, &bFather) ||
-		isSibling(&aFather, &bMother) || isSibling(&aFather, &bFather)
-}
-
-// Finds all the ancestors for a given agent. id is the id of the agent for whom to calculate
-func setAncestors(agents []Agent, id int) {
-	ancestorSet := make(map[int]struct{})
-	ancestorVec := make([]int, 0, agents[id].generation*2)
-	ancestorVec = append(ancestorVec, id)
-	generation := agents[id].generation
-	sp := 0
-	for sp < len(ancestorVec) {
-		curr := ancestorVec[sp]
-		currGen := agents[curr].generation
-		if currGen < 1 { // The zero generation has no ancestry
-			break
+	for _, aParent := range [...]int{a.mother, a.father} {
+		if aParent == Unknown {
+			continue
 		}
-		mother := agents[curr].mother
-		father := agents[curr].father
-		parents := [...]int{mother, father}
-		for _, parent := range parents {
-			if _, found := ancestorSet[parent]; !found {
-				ancestorVec = append(ancestorVec, parent)
-				ancestorSet[parent] = struct{}{}
+		for _, bParent := range [...]int{b.mother, b.father} {
+			if bParent != Unknown && isSibling(&agents[aParent], &agents[bParent]) {
+				return true
 			}
 		}
-		sp += 1
-		if currGen < generation {
-			generation = currGen
+	}
+	return false
+}
+
+// Sets the ancestors of an agent as the union of its parents' ancestors and
+// the parents themselves. The parents' ancestors must already be set.
+// Unknown parents contribute no ancestry.
+func inheritAncestors(agents []Agent, id int) {
+	agent := &agents[id]
+	var ancestors bitset
+	for _, parent := range [...]int{agent.mother, agent.father} {
+		if parent != Unknown {
+			ancestors = ancestors.union(agents[parent].ancestors)
+			ancestors.add(parent)
 		}
 	}
-	slices.Sort(ancestorVec)
-	ancestorVec = ancestorVec[:len(ancestorVec)-1] // Remove self
-	agents[id].ancestorVec = ancestorVec
-	agents[id].ancestorSet = ancestorSet
+	agent.ancestors = ancestors
+	agent.numAncestors = ancestors.count()
 }
 
 // Generic function to count the number of common elements in two arrays
@@ -154,12 +300,9 @@ func CountCommon[S ~[]E, E constraints.Ordered](vecA S, vecB S) int {
 // first agent.
 func generationDiff(agents []Agent, a *Agent, b *Agent) int {
 	generationFound := 0
-	for i := len(a.ancestorVec) - 1; i >= 0; i-- {
-		index := a.ancestorVec[i]
-		if _, found := b.ancestorSet[index]; found {
-			generationFound = agents[index].generation
-			break
-		}
+	// Ids increase with generation so the highest common id is the most recent
+	if index := a.ancestors.lastCommon(b.ancestors); index >= 0 {
+		generationFound = agents[index].generation
 	}
 	return a.generation - generationFound
 }
@@ -189,15 +332,36 @@ type Simulation struct {
 	genBdrys []int
 	// Agents that are paired to reproduce
 	matingPairs []matingPair
+	// Highest generation for which ancestors have been set
+	ancestryGen int
+	// Structured results of the last analysis
+	results Results
+	// Every gene mutation in the order they occurred
+	mutations []Mutation
+	// Number of Y and mitochondrial mutations, used to number haplogroups
+	numLineageMutations int32
+	// Statistics of generations recorded before simplification
+	series generationSeries
+	// Where analysis reports are written, standard output by default
+	out io.Writer
+	// Where problems running the simulation are written, standard error
+	// by default
+	errOut io.Writer
 	// User specified parameters
 	params Parameters
 }
 
-// Creates a new simulation
-func NewSimulation(parameters *Parameters) *Simulation {
+// Creates a new simulation, returning an error if the parameters aren't valid
+func NewSimulation(parameters *Parameters) (*Simulation, error) {
+	if err := parameters.Validate(); err != nil {
+		return nil, err
+	}
 	var simulation Simulation
 	simulation.params = *parameters
+	simulation.params.setDefaults()
 	simulation.id = parameters.SimulationId
+	simulation.out = os.Stdout
+	simulation.errOut = os.Stderr
 	// Create agents
 	for i := range parameters.NumAgents {
 		var sex Sex
@@ -210,12 +374,13 @@ func NewSimulation(parameters *Parameters) *Simulation {
 			id:         i,
 			generation: 0,
 			sex:        sex,
-			mother:     0,
-			father:     0,
-		}
-		for i := range parameters.NumGenes {
-			agent.genes = append(agent.genes, fmt.Sprintf("%d-%d", agent.id, i))
+			mother:     Unknown,
+			father:     Unknown,
 		}
+		agent.genes = simulation.founderGenes(agent.id)
+		agent.chromosomes = simulation.founderChromosomes(agent.id)
+		founderLineages(&agent)
+		agent.surname = int32(agent.id)
 		simulation.agents = append(simulation.agents, agent)
 	}
 	// Set current generation
@@ -227,7 +392,7 @@ func NewSimulation(parameters *Parameters) *Simulation {
 		}
 		simulation.currGen = append(simulation.currGen, selectedAgent)
 	}
-	return &simulation
+	return &simulation, nil
 }
 
 // Checks if two agents are compatible for mating
@@ -269,13 +434,41 @@ func (s *Simulation) setCurrGen(gen int) {
 	}
 }
 
-// Sets the ancestors for every agent in the given generation
+// Sets the ancestors for every agent up to and including the given
+// generation. Each generation is built from the one before it, so
+// generations that already have their ancestors set are skipped. Unless
+// ancestry is tracked, the sets of earlier generations are released as
+// soon as no later set needs them, so that only the sets of the given
+// generation, and of parents with children still to come, are kept.
 func (s *Simulation) setAncestorsGen(gen int) {
-	for i := s.genBdrys[gen-1]; i < s.genBdrys[gen]; i++ {
-		setAncestors(s.agents, i)
+	for g := s.ancestryGen + 1; g <= gen && g < len(s.genBdrys); g++ {
+		for i := s.genBdrys[g-1]; i < s.genBdrys[g]; i++ {
+			inheritAncestors(s.agents, i)
+			if s.params.TrackAncestry {
+				continue
+			}
+			for _, parent := range parentsOf(&s.agents[i]) {
+				if slices.Max(s.agents[parent].children) == i {
+					s.agents[parent].ancestors = nil
+				}
+			}
+		}
+		if !s.params.TrackAncestry && g > 1 {
+			for i := s.genBdrys[g-2]; i < s.genBdrys[g-1]; i++ {
+				if len(s.agents[i].children) == 0 {
+					s.agents[i].ancestors = nil
+				}
+			}
+		}
+		s.ancestryGen = g
 	}
 }
 
+// Returns whether any of the analyses to run need ancestor sets
+func (s *Simulation) needsAncestors() bool {
+	return strings.ContainsAny(s.params.Analysis, "NCDAI")
+}
+
 // Helper function for pairAgents that makes a single pair
 func makePair(agentA *Agent, agentB *Agent) matingPair {
 	var pair matingPair
@@ -314,7 +507,9 @@ func (s *Simulation) pairAgents() {
 	}
 }
 
-func newChild(agents []Agent, father, mother, numGenes, generation int, mutationRate float64) []Agent {
+// Creates a new agent in the given generation whose genes are inherited
+// from the father and mother.
+func (s *Simulation) newChild(father, mother, generation int) {
 	var sex Sex
 	if rand.Float64() < 0.5 {
 		sex = MALE
@@ -322,34 +517,36 @@ func newChild(agents []Agent, father, mother, numGenes, generation int, mutation
 		sex = FEMALE
 	}
 	agent := Agent{
-		id:         len(agents),
+		id:         len(s.agents),
 		generation: generation,
 		sex:        sex,
 		father:     father,
 		mother:     mother,
 	}
-	for i := range numGenes {
-		if rand.Float64() < 0.5 {
-			agent.genes = append(agent.genes, agents[father].genes[i])
-		} else {
-			agent.genes = append(agent.genes, agents[mother].genes[i])
-		}
-		if mutationRate > 0.0 && rand.Float64() < mutationRate {
-			agent.genes[len(agent.genes)-1] += "`"
-		}
-	}
-	agents = append(agents, agent)
-	agents[father].children = append(agents[father].children, agent.id)
-	agents[mother].children = append(agents[mother].children, agent.id)
-	return agents
+	s.inheritGenes(&agent)
+	s.inheritLineages(&agent)
+	s.inheritSurname(&agent)
+	s.agents = append(s.agents, agent)
+	s.agents[father].children = append(s.agents[father].children, agent.id)
+	s.agents[mother].children = append(s.agents[mother].children, agent.id)
 }
 
-// Makes children agents from the mating_pairs vector
+// Makes children agents from the mating_pairs vector. Under selection
+// fitter pairs are more likely to have children.
 func (s *Simulation) makeChildrenMonogamous(generation int) {
 	iterations := int(math.Ceil(s.params.GrowthRate * float64(len(s.currGen))))
+	var pairs sampler
+	if s.selectionActive() {
+		pairs = s.pairSampler()
+	}
 	for range iterations {
-		pair := s.matingPairs[rand.Intn(len(s.matingPairs))]
-		s.agents = newChild(s.agents, pair.male, pair.female, s.params.NumGenes, generation, s.params.MutationRate)
+		var pair matingPair
+		if s.selectionActive() {
+			pair = s.matingPairs[pairs.pick()]
+		} else {
+			pair = s.matingPairs[rand.Intn(len(s.matingPairs))]
+		}
+		s.newChild(pair.male, pair.female, generation)
 	}
 }
 
@@ -359,12 +556,13 @@ func (s *Simulation) monogamousMating(generation int) {
 	if len(s.matingPairs) > 0 {
 		s.makeChildrenMonogamous(generation + 1)
 	} else {
-		fmt.Fprintln(os.Stderr, s.id, "Error: No mating pairs for generation",
+		fmt.Fprintln(s.errOut, s.id, "Error: No mating pairs for generation",
 			generation)
 	}
 }
 
-// Mating strategy in which agents to mate are repeatedly selected to mate with anyone.
+// Mating strategy in which agents to mate are repeatedly selected to mate
+// with anyone. Under selection fitter agents are more likely to be selected.
 func (s *Simulation) nonMonogamousMating(generation int) {
 	iterations := int(math.Ceil(s.params.GrowthRate * float64(len(s.currGen))))
 	var males, females []int
@@ -379,21 +577,30 @@ func (s *Simulation) nonMonogamousMating(generation int) {
 	}
 
 	if len(males) == 0 {
-		fmt.Fprintln(os.Stderr, s.id, "Error: No males to make generation",
+		fmt.Fprintln(s.errOut, s.id, "Error: No males to make generation",
 			generation)
 		return
 	}
 
 	if len(females) == 0 {
-		fmt.Fprintln(os.Stderr, s.id, "Error: No females to make generation",
+		fmt.Fprintln(s.errOut, s.id, "Error: No females to make generation",
 			generation)
 		return
 	}
 
+	if s.selectionActive() {
+		maleSampler := s.fitnessSampler(males)
+		femaleSampler := s.fitnessSampler(females)
+		for range iterations {
+			s.newChild(males[maleSampler.pick()], females[femaleSampler.pick()], generation+1)
+		}
+		return
+	}
+
 	for range iterations {
 		i := males[rand.Intn(len(males))]
 		j := females[rand.Intn(len(females))]
-		s.agents = newChild(s.agents, i, j, s.params.NumGenes, generation, s.params.MutationRate)
+		s.newChild(i, j, generation+1)
 	}
 }
 
@@ -401,33 +608,46 @@ func (s *Simulation) nonMonogamousMating(generation int) {
 // one past the simulation.agents index of the last agent with the generation
 // matching the index of the array. This should generally only be needed for
 // testing purposes because the genBdrys array is maintained by the simulation
-// engine as it generates a new generation of agents.
+// engine as it generates a new generation of agents. Generations without
+// any agents get the same boundary as the generation before them.
 func (s *Simulation) SetGenBdrys() {
 	s.genBdrys = s.genBdrys[:0]
-	if len(s.agents) == 0 {
-		return
-	}
-	gen := s.agents[0].generation
 	for i := range len(s.agents) {
-		if gen != s.agents[i].generation {
-			gen = s.agents[i].generation
+		for len(s.genBdrys) < s.agents[i].generation {
 			s.genBdrys = append(s.genBdrys, i)
 		}
 	}
 	s.genBdrys = append(s.genBdrys, len(s.agents))
 }
 
+// Sets where analysis reports are written
+func (s *Simulation) SetOutput(w io.Writer) {
+	s.out = w
+}
+
+// Sets where problems running the simulation, such as a generation
+// without mating pairs, are written
+func (s *Simulation) SetErrorOutput(w io.Writer) {
+	s.errOut = w
+}
+
+// Returns the number of agents in the simulation. Agent ids run from 0 to
+// one less than this.
+func (s *Simulation) NumAgents() int {
+	return len(s.agents)
+}
+
 // This is the simulation engine function
 func (s *Simulation) Simulate() {
 	s.setCurrGen(0)
 	for i := range s.params.Generations {
 		if len(s.currGen) == 0 {
-			fmt.Println("No survivors for generation", i, ".")
+			fmt.Fprintln(s.errOut, "No survivors for generation", i, ".")
 			break
 
 		}
 		if len(s.currGen) == 1 {
-			fmt.Println("Only one survivor in generation", i, ".")
+			fmt.Fprintln(s.errOut, "Only one survivor in generation", i, ".")
 			break
 		}
 		rand.Shuffle(len(s.currGen), func(x, y int) {
@@ -440,57 +660,44 @@ func (s *Simulation) Simulate() {
 		}
 		s.genBdrys = append(s.genBdrys, len(s.agents))
 		s.setCurrGen(i + 1)
+		if s.params.TrackAncestry {
+			s.setAncestorsGen(i + 1)
+		}
+		if s.params.SimplifyInterval > 0 && (i+1)%s.params.SimplifyInterval == 0 {
+			s.Simplify()
+		}
 	}
 }
 
 // Reports statistics on number of ancestors agents in the last generation have
 func (s *Simulation) reportNumAncestors() {
 	generation := s.agents[len(s.agents)-1].generation
-	count := 0
-	total := 0
-	min_ := math.MaxInt
-	max_ := math.MinInt
+	stats := newDistribution()
 	start := s.genBdrys[generation-1]
 	for _, agent := range s.agents[start:] {
-		numAncestors := len(agent.ancestorVec)
-		total += numAncestors
-		count++
-		if numAncestors < min_ {
-			min_ = numAncestors
-		}
-		if numAncestors > max_ {
-			max_ = numAncestors
-		}
-	}
-	avg := math.Round(float64(total) / float64(count))
-	fmt.Println("Number agents", len(s.agents))
-	fmt.Println("Number agents  last generation ", count)
-	fmt.Printf("Generations: %v Max possible ancestors %v\n", generation, math.Pow(2, float64(generation+1))-2)
-	fmt.Printf("Min, max, mean number of ancestors for agents in last generation: %v %v %v\n", min_, max_, avg)
+		stats.add(agent.numAncestors)
+	}
+	fmt.Fprintln(s.out, "Number agents", len(s.agents))
+	fmt.Fprintln(s.out, "Number agents  last generation ", stats.count)
+	fmt.Fprintf(s.out, "Generations: %v Max possible ancestors %v\n", generation, math.Pow(2, float64(generation+1))-2)
+	fmt.Fprintf(s.out, "Min, max, mean number of ancestors for agents in last generation: %v %v %v\n",
+		stats.min, stats.max, math.Round(stats.mean()))
+	s.results.NumAncestors = s.statistic(stats)
+	s.results.NumAncestors.print(s.out)
 }
 
 // Reports statistics on the number of common ancestors that agents in the last generation have
 func (s *Simulation) reportCommonAncestors() {
 	generation := s.agents[len(s.agents)-1].generation
 	start := s.genBdrys[generation-1]
-	total := 0
-	min_ := math.MaxInt
-	max_ := math.MinInt
-	for _, agent := range s.agents[start : len(s.agents)-1] {
-		for j := agent.id + 1; j < len(s.agents); j++ {
-			common := CountCommon(agent.ancestorVec, s.agents[j].ancestorVec)
-			if common < min_ {
-				min_ = common
-			}
-			if common > max_ {
-				max_ = common
-			}
-			total += common
-		}
-	}
-	pop := len(s.agents) - start
-	avg := math.Round(float64(total) / (float64(pop) * float64(pop) / 2.0))
-	fmt.Printf("Min, max, mean number of common ancestors (for last generation): %v %v %v\n", min_, max_, avg)
+	stats := s.pairStatistics(start, len(s.agents), func(a, b *Agent) int {
+		return a.ancestors.intersectCount(b.ancestors)
+	})
+	fmt.Fprintf(s.out, "%s number of common ancestors (for last generation): %v %v %v\n",
+		stats.summaryLabel(), stats.min, stats.max, math.Round(stats.mean()))
+	fmt.Fprint(s.out, stats.sampleReport())
+	s.results.CommonAncestors = s.statistic(stats)
+	s.results.CommonAncestors.print(s.out)
 }
 
 // Reports statistics on the number of generations back you have to search to
@@ -498,105 +705,78 @@ func (s *Simulation) reportCommonAncestors() {
 func (s *Simulation) reportGenDiff() {
 	lastGen := s.agents[len(s.agents)-1].generation
 	if lastGen == 0 {
-		fmt.Fprintf(os.Stderr, "There is only one generation.\n")
+		fmt.Fprintf(s.errOut, "There is only one generation.\n")
 		return
 	}
-	count := 0
-	total := 0
-	min_ := math.MaxInt
-	max_ := 0
-	for i := len(s.agents) - 1; i >= 0; i-- {
-		a := &s.agents[i]
-		if a.generation != lastGen {
-			break
-		}
-		count++
-		for j := a.id - 1; j > 0; j-- {
-			b := &s.agents[j]
-			if b.generation != lastGen {
-				break
-			}
-			difference := generationDiff(s.agents, a, b)
-			if difference < min_ {
-				min_ = difference
-			}
-			if difference > max_ {
-				max_ = difference
-			}
-			total += difference
-		}
-	}
-	avg := math.Round(float64(total) / (float64(count*count) / 2.0))
-	fmt.Printf("Min, max, mean generation difference (for last generation): %v %v %v\n", min_, max_, avg)
+	start := s.genBdrys[lastGen-1]
+	stats := s.pairStatistics(start, len(s.agents), func(a, b *Agent) int {
+		return generationDiff(s.agents, a, b)
+	})
+	fmt.Fprintf(s.out, "%s generation difference (for last generation): %v %v %v\n",
+		stats.summaryLabel(), stats.min, stats.max, math.Round(stats.mean()))
+	fmt.Fprint(s.out, stats.sampleReport())
+	s.results.GenerationDiff = s.statistic(stats)
+	s.results.GenerationDiff.print(s.out)
 }
 
-// Reports statistics on gene distribution across a slice of agents
-func analyzeGenes(agents []Agent) {
-	geneTable := make(map[string]int)
-	individualTable := make(map[int]int)
+// Reports statistics on allele distribution across a slice of agents.
+// Founders are identified by their ids before any simplification.
+func (s *Simulation) analyzeGenes(w io.Writer, agents []Agent) {
+	geneTable := make(map[allele]int)
+	individualTable := make(map[int32]int)
 	for _, agent := range agents {
 		for _, gene := range agent.genes {
-			geneTable[gene]++
-			components := strings.Split(gene, "-")
-			individual, err1 := strconv.Atoi(components[0])
-			if err1 != nil {
-				fmt.Printf("Error converting gene components to int")
-			} else {
-				individualTable[individual]++
-			}
+			geneTable[s.allele(gene)]++
+			individualTable[gene.Founder]++
 		}
 	}
 	generation := agents[0].generation
-	fmt.Printf("Number of different genes in generation %v: %v\n", generation, len(geneTable))
-	maxGene, maxGeneCnt := "", 0
+	fmt.Fprintf(w, "Number of different genes in generation %v: %v\n", generation, len(geneTable))
+	maxGene, maxGeneCnt := allele{}, 0
 	for k, v := range geneTable {
 		if v > maxGeneCnt {
 			maxGene, maxGeneCnt = k, v
 		}
 	}
-	fmt.Printf("Most common gene: %s: %d\n", maxGene, maxGeneCnt)
-	maxIndividual, maxIndividualCnt := 0, 0
+	fmt.Fprintf(w, "Most common gene: %v: %d\n", maxGene.gene, maxGeneCnt)
+	maxIndividual, maxIndividualCnt := int32(0), 0
 	for k, v := range individualTable {
 		if v > maxIndividualCnt {
 			maxIndividual, maxIndividualCnt = k, v
 		}
 	}
-	fmt.Printf("Number original individuals contributing to gene pool %d\n", len(individualTable))
-	fmt.Printf("Most common individual %d %d\n", maxIndividual, maxIndividualCnt)
+	fmt.Fprintf(w, "Number original individuals contributing to gene pool %d\n", len(individualTable))
+	fmt.Fprintf(w, "Most common individual (original founder id) %d %d\n", maxIndividual, maxIndividualCnt)
+	if s.params.Diploid {
+		s.reportZygosity(w, agents)
+	}
 	// fmt.Printf("Debug: %+v\n%+v\n", geneTable, individualTable)
 }
 
 // Reports gene statistics for a simulation
 func (s *Simulation) reportGenes() {
-	if len(s.agents) == 0 {
-		return
+	for _, report := range s.generationSeries().genes {
+		fmt.Fprint(s.out, report)
 	}
-	start := 0
-	generation := s.agents[0].generation
-	for i, agent := range s.agents {
-		if agent.generation != generation {
-			analyzeGenes(s.agents[start:i])
-			start = i
-			generation = agent.generation
-		}
-	}
-	analyzeGenes(s.agents[start:])
 }
 
 // Reports statistics on the outcome of a simulation
 func (s *Simulation) Analysis() {
-	fmt.Printf("For simulation %v:\n", s.id)
-	fmt.Printf("Parameters: %+v\n", s.params)
+	s.results = Results{}
+	fmt.Fprintf(s.out, "For simulation %v:\n", s.id)
+	fmt.Fprintf(s.out, "Parameters: %+v\n", s.params)
 	if len(s.agents) == 0 {
-		fmt.Printf("No agents in simulation")
+		fmt.Fprintf(s.out, "No agents in simulation")
 		return
 	}
 	generation := s.agents[len(s.agents)-1].generation
 	if generation == 0 {
-		fmt.Printf("Only zero generation exists")
+		fmt.Fprintf(s.out, "Only zero generation exists")
 		return
 	}
-	s.setAncestorsGen(generation)
+	if s.needsAncestors() {
+		s.setAncestorsGen(generation)
+	}
 
 	if strings.Contains(s.params.Analysis, "N") {
 		s.reportNumAncestors()
@@ -613,4 +793,36 @@ func (s *Simulation) Analysis() {
 	if strings.Contains(s.params.Analysis, "G") {
 		s.reportGenes()
 	}
+
+	if strings.Contains(s.params.Analysis, "S") {
+		s.reportSegments()
+	}
+
+	if strings.Contains(s.params.Analysis, "A") {
+		s.reportGeneticAncestors()
+	}
+
+	if strings.Contains(s.params.Analysis, "U") {
+		s.reportLineages()
+	}
+
+	if strings.Contains(s.params.Analysis, "F") {
+		s.reportSurnames()
+	}
+
+	if strings.Contains(s.params.Analysis, "M") {
+		s.reportMutations()
+	}
+
+	if strings.Contains(s.params.Analysis, "T") {
+		s.reportSelection()
+	}
+
+	if strings.Contains(s.params.Analysis, "H") {
+		s.reportDiversity()
+	}
+
+	if strings.Contains(s.params.Analysis, "I") {
+		s.reportIBD()
+	}
 }
@@ -1,9 +1,12 @@
 package abm
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
 	"slices"
+	"strings"
 	"testing"
 )
 
@@ -37,7 +40,8 @@ func TestSetAncestorsGeneral(t *testing.T) {
 		Monogamous:   true,
 		Compatible:   false,
 	}
-	simulation := NewSimulation(&parameters)
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
 	simulation.Simulate()
 	print("Debug A", len(simulation.agents))
 	assert.Equal(t, len(simulation.agents) > 20, true, "At least 21 agents")
@@ -46,37 +50,70 @@ func TestSetAncestorsGeneral(t *testing.T) {
 	simulation.setAncestorsGen(generation)
 	for _, agent := range simulation.agents {
 		if agent.generation == generation {
-			require.Equal(t, len(agent.ancestorSet) > 0, true,
+			require.Equal(t, agent.ancestors.count() > 0, true,
 				"ancestor set has elements for last generation agent")
-			require.Equal(t, len(agent.ancestorVec) > 0, true,
-				"ancestor vector has elements for last generation agent")
-			require.Equal(t, len(agent.ancestorSet), len(agent.ancestorVec),
-				"set and vector have same number of elemnts")
+			for _, ancestor := range agent.ancestors.toSlice() {
+				require.Less(t, simulation.agents[ancestor].generation, generation,
+					"ancestors are from earlier generations")
+			}
 			counter++
+		} else if agent.generation == 0 {
+			require.Equal(t, agent.ancestors.count(), 0, "ancestor set has 0 elements for founders")
 		} else {
-			require.Equal(t, len(agent.ancestorSet), 0, "ancestor set has 0 elements for not last generation agent")
-			require.Equal(t, len(agent.ancestorVec), 0, "ancestor vector has 0 elements for not last generation agent")
+			require.Equal(t, agent.numAncestors > 0, true,
+				"ancestors counted for earlier generation agent")
+			require.Nil(t, agent.ancestors, "ancestor set released for earlier generation agent")
 		}
 	}
 	assert.Equal(t, counter > 0, true, "some agents exist")
 }
 
+func TestReleaseAncestors(t *testing.T) {
+	// Agent q2 in generation 1 is a parent of b in generation 3
+	parameters := NewParameters()
+	simulation, err := ReadCSV(strings.NewReader(
+		"c1,M,,\nc2,F,,\nr,F,,c1\nm,F,c2,\nq2,F,c2,\nq,M,r,\na,M,m,c1\nb,F,q2,q\n"), &parameters)
+	require.NoError(t, err, "Read CSV pedigree")
+	simulation.setAncestorsGen(2)
+	assert.NotNil(t, simulation.agents[4].ancestors, "Set kept for parent with a child still to come")
+	simulation.setAncestorsGen(3)
+	for id, agent := range simulation.agents {
+		ancestors, err := simulation.Ancestors(id, 0)
+		require.NoError(t, err, "Ancestors")
+		assert.Equal(t, len(ancestors), agent.numAncestors, "Number of ancestors of %d", id)
+		if agent.generation < 3 {
+			assert.Nil(t, agent.ancestors, "Set of %d released", id)
+		} else {
+			assert.Equal(t, ancestors, agent.ancestors.toSlice(), "Set of %d kept", id)
+		}
+	}
+
+	parameters.NumAgents = 20
+	parameters.Analysis = "G"
+	simulated, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulated.SetOutput(io.Discard)
+	simulated.Simulate()
+	simulated.Analysis()
+	assert.Equal(t, 0, simulated.ancestryGen, "No ancestor sets built if no analysis needs them")
+}
+
 func setupSim(t *testing.T) *Simulation {
 	agents := []Agent{
 		{
 			id:         0,
 			generation: 0,
 			sex:        MALE,
-			mother:     0,
-			father:     0,
+			mother:     Unknown,
+			father:     Unknown,
 			children:   []int{2, 3, 4},
 		},
 		{
 			id:         1,
 			generation: 0,
 			sex:        MALE,
-			mother:     0,
-			father:     0,
+			mother:     Unknown,
+			father:     Unknown,
 			children:   []int{2, 3, 4},
 		},
 		{
@@ -171,7 +208,8 @@ func setupSim(t *testing.T) *Simulation {
 		},
 	}
 	parameters := NewParameters()
-	simulation := NewSimulation(&parameters)
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
 	simulation.agents = agents
 	simulation.SetGenBdrys()
 	simulation.setCurrGen(3)
@@ -185,14 +223,6 @@ func setupSim(t *testing.T) *Simulation {
 	return simulation
 }
 
-func setToVec(m map[int]struct{}) []int {
-	result := make([]int, 0)
-	for key := range m {
-		result = append(result, key)
-	}
-	return result
-}
-
 func TestSetAncestorsSpecific(t *testing.T) {
 	simulation := setupSim(t)
 	simulation.setAncestorsGen(simulation.agents[len(simulation.agents)-1].generation)
@@ -200,22 +230,904 @@ func TestSetAncestorsSpecific(t *testing.T) {
 		assert.Equal(t, simulation.agents[9].id, 9, "ID being set correctly")
 		assert.Equal(t,
 			[]int{0, 1, 3, 4, 5, 7},
-			simulation.agents[9].ancestorVec,
-			"Expected entries in ancestor vec")
-
-		vecFromSet := setToVec(simulation.agents[9].ancestorSet)
-		slices.Sort(vecFromSet)
-		assert.Equal(t, simulation.agents[9].ancestorVec, vecFromSet, "Set and vec are equal")
+			simulation.agents[9].ancestors.toSlice(),
+			"Expected entries in ancestor set")
+		assert.Equal(t, simulation.agents[9].ancestors.count(), 6, "Count matches entries")
 	}
 	{
 		agent := simulation.agents[len(simulation.agents)-1]
 		assert.Equal(t,
 			[]int{0, 1, 3, 4, 6, 8},
-			agent.ancestorVec,
-			"Expected entries in ancestor vec")
+			agent.ancestors.toSlice(),
+			"Expected entries in ancestor set")
+		assert.Equal(t, agent.ancestors.has(5), false, "Agent 5 is not an ancestor")
+	}
+}
+
+// Finds ancestors by walking the pedigree so that the incrementally
+// calculated ancestors can be checked against it.
+func walkAncestors(agents []Agent, id int) []int {
+	found := make(map[int]bool)
+	queue := []int{id}
+	for sp := 0; sp < len(queue); sp++ {
+		agent := agents[queue[sp]]
+		if agent.generation == 0 {
+			continue
+		}
+		for _, parent := range []int{agent.mother, agent.father} {
+			if !found[parent] {
+				found[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	result := make([]int, 0, len(found))
+	for ancestor := range found {
+		result = append(result, ancestor)
+	}
+	slices.Sort(result)
+	return result
+}
+
+func TestTrackAncestry(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumAgents = 50
+	parameters.Generations = 5
+	parameters.TrackAncestry = true
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulation.Simulate()
+	lastGen := simulation.agents[len(simulation.agents)-1].generation
+	assert.Equal(t, simulation.ancestryGen, lastGen, "Ancestry set during simulation")
+	for _, agent := range simulation.agents {
+		require.Equal(t, walkAncestors(simulation.agents, agent.id),
+			agent.ancestors.toSlice(), "Incremental ancestry matches pedigree walk")
+	}
+}
+
+func TestNonMonogamousGenerations(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumAgents = 40
+	parameters.Generations = 4
+	parameters.Monogamous = false
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulation.Simulate()
+	for _, agent := range simulation.agents[parameters.NumAgents:] {
+		mother := simulation.agents[agent.mother]
+		require.Equal(t, mother.generation+1, agent.generation,
+			"Children are in the generation after their parents")
+	}
+	lastGen := simulation.agents[len(simulation.agents)-1].generation
+	assert.Equal(t, lastGen, parameters.Generations, "One generation per iteration")
+	assert.Equal(t, len(simulation.genBdrys), lastGen+1, "Boundary per generation")
+}
+
+func TestBitset(t *testing.T) {
+	var a, b bitset
+	for _, id := range []int{1, 5, 64, 130} {
+		a.add(id)
+	}
+	for _, id := range []int{0, 5, 130, 200} {
+		b.add(id)
+	}
+	assert.Equal(t, a.count(), 4, "Count of first set")
+	assert.Equal(t, a.has(64), true, "Set contains added id")
+	assert.Equal(t, a.has(63), false, "Set doesn't contain id not added")
+	assert.Equal(t, a.has(1000), false, "Set doesn't contain id past its end")
+	assert.Equal(t, a.intersectCount(b), 2, "Intersection count")
+	assert.Equal(t, a.lastCommon(b), 130, "Highest common id")
+	assert.Equal(t, a.union(b).toSlice(), []int{0, 1, 5, 64, 130, 200}, "Union of sets")
+	assert.Equal(t, b.toSlice(), []int{0, 5, 130, 200}, "Ids in ascending order")
+	var empty bitset
+	assert.Equal(t, a.lastCommon(empty), -1, "No common id with empty set")
+}
 
-		vecFromSet := setToVec(agent.ancestorSet)
-		slices.Sort(vecFromSet)
-		assert.Equal(t, agent.ancestorVec, vecFromSet, "Set and vec are equal")
+func TestPairwise(t *testing.T) {
+	simulation := setupSim(t)
+	simulation.setAncestorsGen(3)
+	// Agents 9 & 10 and 11, 12 & 13 are siblings, the two families are cousins.
+	for _, workers := range []int{1, 2, 3, 8} {
+		simulation.params.Workers = workers
+		stats := simulation.pairwise(9, 14, func(a, b *Agent) int {
+			return generationDiff(simulation.agents, a, b)
+		})
+		assert.Equal(t, stats.count, 10, "Every pair counted once")
+		assert.Equal(t, stats.min, 1, "Siblings are one generation apart")
+		assert.Equal(t, stats.max, 2, "Cousins are two generations apart")
+		assert.Equal(t, stats.sum, 4*1+6*2, "Sum of generation differences")
+	}
+}
+
+func TestPairSample(t *testing.T) {
+	simulation := setupSim(t)
+	simulation.setAncestorsGen(3)
+	stats := simulation.pairStatistics(9, 14, func(a, b *Agent) int {
+		return 0
+	})
+	assert.Equal(t, stats.sampled, false, "All pairs used if no sample requested")
+	assert.Equal(t, stats.summaryLabel(), "Min, max, mean", "Exact min and max")
+	simulation.params.PairSample = 5
+	stats = simulation.pairStatistics(9, 14, func(a, b *Agent) int {
+		return 0
+	})
+	assert.Equal(t, stats.sampled, true, "Sample used if smaller than number of pairs")
+	assert.Equal(t, stats.summaryLabel(), "Sample min, max, mean", "Min and max labelled as sampled")
+	stats = simulation.pairwiseSample(9, 14, 1000, func(a, b *Agent) int {
+		assert.NotEqual(t, a.id, b.id, "Sampled pairs are distinct agents")
+		return generationDiff(simulation.agents, a, b)
+	})
+	assert.Equal(t, stats.sampled, true, "Statistics are sampled")
+	assert.Equal(t, stats.count, 1000, "Requested number of pairs sampled")
+	lo, hi := stats.confInt()
+	assert.Less(t, lo, stats.mean(), "Lower bound below mean")
+	assert.Greater(t, hi, stats.mean(), "Upper bound above mean")
+	assert.InDelta(t, stats.mean(), 1.6, 0.1, "Estimated mean near true mean")
+}
+
+func TestSimplify(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumAgents = 60
+	parameters.Generations = 6
+	parameters.GrowthRate = 0.8
+	parameters.TrackAncestry = true
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulation.Simulate()
+	lastGen := simulation.agents[len(simulation.agents)-1].generation
+	start := simulation.genBdrys[lastGen-1]
+	numLast := len(simulation.agents) - start
+	var counts []int
+	for _, agent := range simulation.agents[start:] {
+		counts = append(counts, agent.ancestors.count())
+	}
+	common := simulation.pairwise(start, len(simulation.agents), func(a, b *Agent) int {
+		return a.ancestors.intersectCount(b.ancestors)
+	})
+
+	before := len(simulation.agents)
+	removed := simulation.Simplify()
+	assert.Equal(t, before-removed, len(simulation.agents), "Removed agents are gone")
+	assert.Equal(t, len(simulation.currGen), numLast, "Current generation is kept")
+	start = simulation.genBdrys[lastGen-1]
+	for i, agent := range simulation.agents {
+		require.Equal(t, agent.id, i, "Ids are renumbered")
+		for _, child := range agent.children {
+			require.Equal(t, simulation.agents[child].generation, agent.generation+1,
+				"Children are renumbered")
+		}
+		if agent.generation < lastGen {
+			require.NotEmpty(t, agent.children, "Only ancestors kept")
+		}
+	}
+	for i, agent := range simulation.agents[start:] {
+		require.Equal(t, counts[i], agent.ancestors.count(), "Ancestor counts unchanged")
+		require.Equal(t, walkAncestors(simulation.agents, agent.id),
+			agent.ancestors.toSlice(), "Renumbered ancestry matches pedigree")
+	}
+	assert.Equal(t, common, simulation.pairwise(start, len(simulation.agents), func(a, b *Agent) int {
+		return a.ancestors.intersectCount(b.ancestors)
+	}), "Common ancestor statistics unchanged")
+	var out strings.Builder
+	simulation.analyzeGenes(&out, simulation.agents[start:])
+	assert.Contains(t, out.String(), "Most common individual (original founder id)",
+		"Founder ids labelled as original after simplification")
+}
+
+func TestSimplifiedSeries(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumAgents = 60
+	parameters.Generations = 5
+	parameters.SimplifyInterval = 1
+	parameters.Surnames = "father"
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	males := 0
+	for _, agent := range simulation.agents {
+		if agent.sex == MALE {
+			males++
+		}
+	}
+	simulation.Simulate()
+	lastGen := simulation.agents[len(simulation.agents)-1].generation
+	require.Less(t, simulation.genBdrys[0], parameters.NumAgents, "Founders without descendants removed")
+
+	series := simulation.generationSeries()
+	assert.Len(t, series.founderSurnames, males, "Every male founder counted")
+	assert.Len(t, series.lineages, lastGen+1, "Lineages of every generation")
+	assert.Len(t, series.surnames, lastGen+1, "Surnames of every generation")
+	bearers := 0
+	for _, n := range series.offspring {
+		bearers += n
+	}
+	assert.GreaterOrEqual(t, bearers, males, "Children of removed surname bearers counted")
+	diversity := simulation.Diversity()
+	require.Len(t, diversity, lastGen+1, "Diversity of every generation")
+	assert.Equal(t, parameters.NumAgents*parameters.NumGenes, diversity[0].Alleles,
+		"Alleles of removed founders counted")
+	var out strings.Builder
+	simulation.SetOutput(&out)
+	simulation.reportGenes()
+	assert.Contains(t, out.String(), fmt.Sprintf("Number of different genes in generation 0: %d\n",
+		parameters.NumAgents*parameters.NumGenes), "Gene report of every founder")
+}
+
+func TestGenes(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumAgents = 40
+	parameters.Generations = 3
+	parameters.MutationRate = 1.0
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulation.Simulate()
+	for _, agent := range simulation.agents {
+		require.Len(t, agent.genes, parameters.NumGenes, "Agent has a gene per locus")
+		for i, gene := range agent.genes {
+			require.Equal(t, int(gene.Locus), i, "Gene is at its locus")
+			require.Equal(t, simulation.agents[gene.Founder].generation, 0, "Gene comes from a founder")
+			require.Equal(t, agent.generation > 0, gene.Lineage > 0, "Every inherited gene mutates")
+		}
+	}
+	assert.Equal(t, Gene{Founder: 12, Locus: 3}.String(), "12-3", "Unmutated gene format")
+	assert.Equal(t, Gene{Founder: 12, Locus: 3, Lineage: 7}.String(), "12-3.7", "Mutated gene format")
+}
+
+func TestDiploid(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumAgents = 40
+	parameters.Generations = 3
+	parameters.Diploid = true
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulation.Simulate()
+	for _, agent := range simulation.agents {
+		require.Len(t, agent.genes, 2*parameters.NumGenes, "Agent has two genes per locus")
+		if agent.generation == 0 {
+			continue
+		}
+		father := simulation.agents[agent.father]
+		mother := simulation.agents[agent.mother]
+		for locus := range parameters.NumGenes {
+			require.Contains(t, father.genes[2*locus:2*locus+2], agent.genes[2*locus],
+				"First copy comes from father")
+			require.Contains(t, mother.genes[2*locus:2*locus+2], agent.genes[2*locus+1],
+				"Second copy comes from mother")
+		}
+	}
+	assert.Equal(t, Gene{Founder: 12, Locus: 3, Haplotype: 1}.String(), "12/1-3", "Second founder copy format")
+}
+
+func TestRecombine(t *testing.T) {
+	copies := [2][]Segment{
+		{{Start: 0.0, End: 0.5, Founder: 1}, {Start: 0.5, End: 1.0, Founder: 2}},
+		{{Start: 0.0, End: 1.0, Founder: 3}},
+	}
+	m := meiosis{breaks: []float64{0.3, 0.6}, first: 0}
+	assert.Equal(t, []Segment{
+		{Start: 0.0, End: 0.3, Founder: 1},
+		{Start: 0.3, End: 0.6, Founder: 3},
+		{Start: 0.6, End: 1.0, Founder: 2},
+	}, m.recombine(copies, 1.0), "Segments taken from each copy in turn")
+	assert.Equal(t, 0, m.source(0.1), "First copy before first crossover")
+	assert.Equal(t, 1, m.source(0.4), "Second copy after first crossover")
+	assert.Equal(t, 0, m.source(0.9), "First copy after second crossover")
+	m = meiosis{breaks: []float64{0.7}, first: 1}
+	assert.Equal(t, []Segment{{Start: 0.0, End: 1.0, Founder: 3}},
+		m.recombine([2][]Segment{copies[1], copies[1]}, 1.0), "Adjacent segments are joined")
+}
+
+func TestChromosomes(t *testing.T) {
+	for _, diploid := range []bool{false, true} {
+		parameters := NewParameters()
+		parameters.NumAgents = 40
+		parameters.Generations = 4
+		parameters.NumGenes = 20
+		parameters.Chromosomes = 3
+		parameters.ChromosomeLength = 2.0
+		parameters.Diploid = diploid
+		simulation, err := NewSimulation(&parameters)
+		require.NoError(t, err, "Valid parameters")
+		simulation.Simulate()
+		ploidy := simulation.ploidy()
+		for _, agent := range simulation.agents {
+			require.Len(t, agent.chromosomes, 3*ploidy, "Agent has every chromosome copy")
+			for _, chromosome := range agent.chromosomes {
+				require.Equal(t, 0.0, chromosome[0].Start, "Chromosome starts at 0")
+				require.Equal(t, 2.0, chromosome[len(chromosome)-1].End, "Chromosome ends at its length")
+				for i := 1; i < len(chromosome); i++ {
+					require.Equal(t, chromosome[i-1].End, chromosome[i].Start, "Segments are contiguous")
+				}
+			}
+			// Without mutation a gene comes from the founder whose segment it lies on
+			for locus := range parameters.NumGenes {
+				c, position := simulation.locusPosition(locus)
+				for h := range ploidy {
+					gene := agent.genes[locus*ploidy+h]
+					for _, seg := range agent.chromosomes[c*ploidy+h] {
+						if seg.Start <= position && position < seg.End {
+							require.Equal(t, seg.Founder, gene.Founder, "Gene founder matches segment")
+							require.Equal(t, seg.Haplotype, gene.Haplotype, "Gene haplotype matches segment")
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestGeneticAncestors(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumAgents = 60
+	parameters.Generations = 5
+	parameters.Diploid = true
+	parameters.TrackAncestry = true
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulation.Simulate()
+	for _, agent := range simulation.agents {
+		genetic := simulation.geneticAncestors(agent.id)
+		assert.Equal(t, genetic.count(), genetic.intersectCount(agent.ancestors),
+			"Genetic ancestors are genealogical ancestors")
+		perGeneration := make(map[int]int)
+		genetic.each(func(id int) {
+			perGeneration[simulation.agents[id].generation]++
+		})
+		for gen, count := range perGeneration {
+			require.LessOrEqual(t, count, 2*parameters.NumGenes,
+				"At most one genetic ancestor per gene in each generation")
+			if gen == agent.generation-1 {
+				require.Equal(t, count, 2, "Both parents contribute genes")
+			}
+		}
+	}
+
+	parameters.Analysis = "A"
+	imported, err := ReadCSV(strings.NewReader("a,M,,\nb,F,,\nc,F,b,a\n"), &parameters)
+	require.NoError(t, err, "Read CSV pedigree")
+	var out strings.Builder
+	imported.SetOutput(&out)
+	imported.Analysis()
+	assert.Contains(t, out.String(), "No genes in simulation, so genetic ancestors are unknown",
+		"Imported pedigrees have no genetic ancestors")
+	assert.NotContains(t, out.String(), "Generations back", "No genetic ancestor table")
+}
+
+func TestUniparentalLineages(t *testing.T) {
+	simulation := setupSim(t)
+	ids := []int{9, 10, 11, 12, 13}
+	id, found := simulation.uniparentalMRCA(ids, func(a *Agent) int { return a.mother })
+	assert.Equal(t, true, found, "Maternal lines meet")
+	assert.Equal(t, 3, id, "Maternal MRCA")
+	id, found = simulation.uniparentalMRCA(ids, func(a *Agent) int { return a.father })
+	assert.Equal(t, true, found, "Paternal lines meet")
+	assert.Equal(t, 4, id, "Paternal MRCA")
+	_, found = simulation.uniparentalMRCA([]int{0, 1}, func(a *Agent) int { return a.mother })
+	assert.Equal(t, false, found, "Lines that reach the founders don't meet")
+
+	parameters := NewParameters()
+	parameters.NumAgents = 40
+	parameters.Generations = 3
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulation.Simulate()
+	for _, agent := range simulation.agents[parameters.NumAgents:] {
+		mother := simulation.agents[agent.mother]
+		require.Equal(t, mother.mtLineage, agent.mtLineage, "mtDNA comes from mother")
+		if agent.sex == MALE {
+			father := simulation.agents[agent.father]
+			require.Equal(t, father.yLineage, agent.yLineage, "Y comes from father")
+		}
 	}
 }
+
+func TestSurnames(t *testing.T) {
+	q := extinctionProbabilities([]float64{0.25, 0.5, 0.25}, 2)
+	assert.InDeltaSlice(t, []float64{0.0, 0.25, 0.390625}, q, 1e-12, "Extinction by generation")
+	q = extinctionProbabilities([]float64{0.25, 0.25, 0.5}, extinctionGenerations)
+	assert.InDelta(t, 0.5, q[extinctionGenerations], 1e-9, "Eventual extinction is smallest fixed point")
+
+	parameters := NewParameters()
+	parameters.NumAgents = 40
+	parameters.Generations = 3
+	parameters.Surnames = "mother"
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulation.Simulate()
+	for _, agent := range simulation.agents[parameters.NumAgents:] {
+		require.Equal(t, simulation.agents[agent.mother].surname, agent.surname,
+			"Surname comes from mother")
+	}
+}
+
+func TestMutationModels(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumAgents = 40
+	parameters.Generations = 3
+	parameters.MutationRate = 1.0
+	parameters.LocusMutationRates = []float64{0.0}
+	parameters.MutationModel = STEPWISE
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulation.Simulate()
+	for _, agent := range simulation.agents[parameters.NumAgents:] {
+		require.Equal(t, int32(0), agent.genes[0].Lineage, "Locus with zero rate doesn't mutate")
+		for _, gene := range agent.genes[1:] {
+			m := simulation.mutation(gene.Lineage)
+			require.Equal(t, agent.id, m.Agent, "Mutation arose in agent")
+			require.Equal(t, agent.generation, m.Generation, "Mutation arose in agent's generation")
+			require.Equal(t, gene.Locus, m.Locus, "Mutation is at gene's locus")
+			parentState := int32(0)
+			if m.Parent != 0 {
+				parentState = simulation.mutation(m.Parent).State
+			}
+			require.Equal(t, int32(1), abs(m.State-parentState), "Stepwise mutation changes one repeat")
+		}
+	}
+	a := Gene{Founder: 1, Locus: 1}
+	b := a
+	a = simulation.mutate(a, &simulation.agents[0])
+	b = simulation.mutate(b, &simulation.agents[0])
+	a = simulation.mutate(a, &simulation.agents[0])
+	b = simulation.mutate(b, &simulation.agents[0])
+	if simulation.mutation(a.Lineage).State == simulation.mutation(b.Lineage).State {
+		assert.Equal(t, simulation.allele(a), simulation.allele(b), "Same repeat count is the same allele")
+	} else {
+		assert.NotEqual(t, simulation.allele(a), simulation.allele(b), "Different repeat counts differ")
+	}
+	simulation.params.MutationModel = INFINITE_ALLELES
+	assert.NotEqual(t, simulation.allele(a), simulation.allele(b), "Independent mutations differ")
+
+	parameters.MutationModel = ""
+	parameters.Dominance = ""
+	simulation, err = NewSimulation(&parameters)
+	require.NoError(t, err, "Empty mutation model is valid")
+	assert.Equal(t, INFINITE_ALLELES, simulation.params.MutationModel, "Default mutation model used")
+	assert.Equal(t, ADDITIVE, simulation.params.Dominance, "Default dominance used")
+	var out strings.Builder
+	simulation.SetOutput(&out)
+	simulation.Simulate()
+	simulation.reportMutations()
+	assert.Contains(t, out.String(), "Mutation model infinite-alleles: ", "Default mutation model reported")
+}
+
+func abs(x int32) int32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func TestSelection(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumGenes = 2
+	parameters.Diploid = true
+	parameters.SelectedLoci = 1
+	parameters.SelectionCoefficient = 0.5
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	homozygote := Agent{genes: []Gene{{Variant: true}, {Variant: true}, {}, {}}}
+	heterozygote := Agent{genes: []Gene{{Variant: true}, {}, {Variant: true}, {Variant: true}}}
+	expected := map[string]float64{ADDITIVE: 1.25, DOMINANT: 1.5, RECESSIVE: 1.0}
+	for dominance, w := range expected {
+		simulation.params.Dominance = dominance
+		assert.Equal(t, 1.5, simulation.fitness(&homozygote), "Homozygote gets full effect")
+		assert.Equal(t, w, simulation.fitness(&heterozygote), "Heterozygote effect depends on dominance")
+	}
+
+	sm := newSampler([]float64{0.0, 2.0, 0.0})
+	for range 100 {
+		require.Equal(t, 1, sm.pick(), "Only index with weight is picked")
+	}
+	sm = newSampler([]float64{0.0, 0.0})
+	for range 100 {
+		require.Contains(t, []int{0, 1}, sm.pick(), "Zero weights pick uniformly")
+	}
+}
+
+func TestDiversity(t *testing.T) {
+	parameters := NewParameters()
+	parameters.NumAgents = 4
+	parameters.NumGenes = 2
+	simulation, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	// Make the second locus fixed for one founder's gene
+	for i := range simulation.agents {
+		simulation.agents[i].genes[1] = simulation.agents[0].genes[1]
+	}
+	diversity := simulation.Diversity()
+	require.Len(t, diversity, 1, "Statistics for the founder generation")
+	stats := diversity[0]
+	assert.InDelta(t, (0.75+0.0)/2, stats.ExpectedHeterozygosity, 1e-12, "Mean expected heterozygosity")
+	assert.InDelta(t, (4.0+1.0)/2, stats.EffectiveAlleles, 1e-12, "Mean effective number of alleles")
+	assert.Equal(t, 5, stats.Alleles, "Number of alleles")
+	assert.Equal(t, 1, stats.Fixed, "Number of fixed loci")
+	assert.Equal(t, []int{0, 0, 4, 0, 0, 0, 0, 0, 0, 1}, stats.Spectrum, "Allele frequency spectrum")
+}
+
+func TestIBD(t *testing.T) {
+	a := []Segment{{Start: 0.0, End: 0.4, Founder: 1}, {Start: 0.4, End: 1.0, Founder: 2}}
+	b := []Segment{{Start: 0.0, End: 0.2, Founder: 2}, {Start: 0.2, End: 0.7, Founder: 1}, {Start: 0.7, End: 1.0, Founder: 2}}
+	assert.Equal(t, []Segment{
+		{Start: 0.2, End: 0.4, Founder: 1},
+		{Start: 0.7, End: 1.0, Founder: 2},
+	}, sharedSegments(a, b), "Overlaps with the same origin are shared")
+	assert.Empty(t, sharedSegments(a, []Segment{{Start: 0.0, End: 1.0, Founder: 1, Haplotype: 1}}),
+		"Different founder copies aren't shared")
+	assert.Equal(t, []Segment{{Start: 0.1, End: 0.5, Founder: 1}, {Start: 0.6, End: 0.7, Founder: 3}},
+		mergeSegments([]Segment{{Start: 0.6, End: 0.7, Founder: 3}, {Start: 0.3, End: 0.5, Founder: 2},
+			{Start: 0.1, End: 0.3, Founder: 1}}), "Touching segments are merged")
+	assert.Equal(t, "siblings", relationshipName(1), "Relationship one generation back")
+	assert.Equal(t, "1st cousins", relationshipName(2), "Relationship two generations back")
+	assert.Equal(t, "12th cousins", relationshipName(13), "Relationship thirteen generations back")
+	assert.Equal(t, "22nd cousins", relationshipName(23), "Relationship twenty three generations back")
+}
+
+func TestDistribution(t *testing.T) {
+	d := newDistribution()
+	for _, value := range []int{1, 2, 2, 3, 10} {
+		d.add(value)
+	}
+	other := newDistribution()
+	other.add(4)
+	d.merge(other)
+	assert.Equal(t, 6, d.count, "Count of merged distribution")
+	assert.Equal(t, 1, d.min, "Minimum")
+	assert.Equal(t, 10, d.max, "Maximum")
+	assert.Equal(t, 2.5, d.quantile(0.5), "Median interpolates between middle values")
+	assert.Equal(t, 1.0, d.quantile(0.0), "Zero quantile is minimum")
+	assert.Equal(t, 10.0, d.quantile(1.0), "One quantile is maximum")
+	assert.Equal(t, 2.0, d.quantile(0.25), "Lower quartile")
+	assert.Equal(t, []Bin{{1, 4, 5}, {5, 8, 0}, {9, 10, 1}}, d.histogram(3), "Histogram bins")
+
+	negative := newDistribution()
+	for _, value := range []int{-3, 2, -1, -3} {
+		negative.add(value)
+	}
+	other = newDistribution()
+	other.add(-5)
+	negative.merge(other)
+	assert.Equal(t, 5, negative.count, "Negative values counted")
+	assert.Equal(t, -5, negative.min, "Negative minimum")
+	assert.Equal(t, -2.0, negative.mean(), "Mean of negative values")
+	assert.Equal(t, -3.0, negative.quantile(0.5), "Median of negative values")
+	assert.Equal(t, -1.0, negative.quantile(0.75), "Upper quartile of negative values")
+	assert.Equal(t, []Bin{{-5, -3, 3}, {-2, 0, 1}, {1, 2, 1}}, negative.histogram(3),
+		"Histogram of negative values")
+
+	simulation := setupSim(t)
+	simulation.params.Analysis = "NCD"
+	simulation.Analysis()
+	results := simulation.Results()
+	require.NotNil(t, results.NumAncestors, "Number of ancestors in results")
+	assert.Equal(t, 5, results.NumAncestors.Count, "Ancestor counts of last generation")
+	assert.Equal(t, 6.0, results.NumAncestors.Median, "Median number of ancestors")
+	require.NotNil(t, results.GenerationDiff, "Generation differences in results")
+	assert.Equal(t, 10, results.GenerationDiff.Count, "Every pair of last generation")
+}
+
+func TestGEDCOM(t *testing.T) {
+	simulation := setupSim(t)
+	var all strings.Builder
+	require.NoError(t, simulation.WriteGEDCOM(&all, nil), "Write every agent")
+	text := all.String()
+	assert.True(t, strings.HasPrefix(text, "0 HEAD\n"), "File starts with header")
+	assert.True(t, strings.HasSuffix(text, "0 TRLR\n"), "File ends with trailer")
+	assert.Contains(t, text, "2 VERS 5.5.1\n", "GEDCOM version")
+	assert.Equal(t, 14, strings.Count(text, " INDI\n"), "Individual for every agent")
+	assert.Equal(t, 4, strings.Count(text, " FAM\n"), "Family for every couple")
+
+	var selected strings.Builder
+	require.NoError(t, simulation.WriteGEDCOM(&selected, []int{9}), "Write ancestry of agent 9")
+	text = selected.String()
+	assert.Equal(t, 7, strings.Count(text, " INDI\n"), "Agent 9 and its ancestors")
+	assert.Equal(t, 3, strings.Count(text, " FAM\n"), "Families in agent 9's ancestry")
+	assert.Contains(t, text, "0 @I9@ INDI\n1 NAME Agent 9\n1 SEX M\n1 BIRT\n2 DATE 1075\n",
+		"Agent 9 with pseudo birth year")
+	assert.NotContains(t, text, "@I11@", "Agents outside the ancestry are left out")
+
+	assert.Error(t, simulation.WriteGEDCOM(&selected, []int{14}), "Unknown agent")
+}
+
+func TestDOT(t *testing.T) {
+	simulation := setupSim(t)
+	var ancestry strings.Builder
+	require.NoError(t, simulation.WriteAncestryDOT(&ancestry, 9, 0), "Write ancestry of agent 9")
+	text := ancestry.String()
+	assert.True(t, strings.HasPrefix(text, "digraph pedigree {\n"), "DOT graph")
+	assert.Equal(t, 7, strings.Count(text, "fillcolor"), "Repeated ancestors drawn once")
+	assert.Equal(t, 10, strings.Count(text, " -> "), "Edge from each parent in the graph")
+	assert.Contains(t, text, "  4 -> 7;\n", "Edge from parent to child")
+	assert.Contains(t, text, "6 distinct ancestors", "Number of ancestors in label")
+
+	var shallow strings.Builder
+	require.NoError(t, simulation.WriteAncestryDOT(&shallow, 9, 1), "Write parents of agent 9")
+	assert.Equal(t, 3, strings.Count(shallow.String(), "fillcolor"), "Agent and its parents")
+
+	var descendancy strings.Builder
+	require.NoError(t, simulation.WriteDescendancyDOT(&descendancy, 3, 0), "Write descendants of agent 3")
+	text = descendancy.String()
+	assert.Equal(t, 10, strings.Count(text, "fillcolor"), "Agent 3 and its descendants")
+	assert.NotContains(t, text, "  0 -> ", "Agent 3's parents are left out")
+
+	assert.Error(t, simulation.WriteAncestryDOT(&ancestry, -1, 0), "Unknown agent")
+}
+
+func TestImport(t *testing.T) {
+	csvText := `id,sex,mother,father,generation
+ann,F,,,
+bob,M,,,
+cat,F,ann,bob,
+dan,M,NA,bob,
+eve,U,cat,dan,
+`
+	parameters := NewParameters()
+	simulation, err := ReadCSV(strings.NewReader(csvText), &parameters)
+	require.NoError(t, err, "Read CSV pedigree")
+	require.Equal(t, 5, len(simulation.agents), "Every individual")
+	assert.Equal(t, []int{2, 4, 5}, simulation.genBdrys, "Generation boundaries")
+	eve := simulation.agents[4]
+	assert.Equal(t, 2, eve.generation, "Generation calculated from parents")
+	assert.Equal(t, FEMALE, simulation.agents[eve.mother].sex, "Mother of eve")
+	assert.Equal(t, UNKNOWN, eve.sex, "Unknown sex kept")
+	dan := simulation.agents[3]
+	assert.Equal(t, Unknown, dan.mother, "Dan's mother is unknown")
+	assert.Equal(t, 1, dan.father, "Dan's father is bob")
+	simulation.setAncestorsGen(2)
+	assert.Equal(t, []int{0, 1, 2, 3}, simulation.agents[4].ancestors.toSlice(), "Known ancestors of eve")
+	assert.Equal(t, []int{2, 3}, simulation.agents[1].children, "Children of bob")
+	assert.True(t, isSibling(&simulation.agents[2], &dan), "Half siblings through bob")
+	assert.False(t, isSibling(&simulation.agents[0], &simulation.agents[1]), "Founders aren't siblings")
+	var partial strings.Builder
+	require.NoError(t, simulation.WriteGEDCOM(&partial, nil), "Write pedigree with an unknown parent")
+	assert.Contains(t, partial.String(), "0 @F1@ FAM\n1 HUSB @I1@\n1 CHIL @I3@\n", "Family without a mother")
+	assert.Contains(t, partial.String(), "0 @I4@ INDI\n1 NAME Agent 4\n1 SEX U\n", "Unknown sex written")
+	reread, err := ReadGEDCOM(strings.NewReader(partial.String()), &parameters)
+	require.NoError(t, err, "Read GEDCOM with an unknown sex")
+	assert.Equal(t, UNKNOWN, reread.agents[4].sex, "Unknown sex read")
+	var table strings.Builder
+	require.NoError(t, simulation.WriteAgentsCSV(&table, -1), "Write agent table")
+	assert.Contains(t, table.String(), "\n4,2,U,", "Unknown sex in agent table")
+	r, err := simulation.Relationship(4, 2)
+	require.NoError(t, err, "Relationship")
+	assert.Equal(t, "child", r.Name, "Relationship of unknown sex")
+
+	_, err = ReadCSV(strings.NewReader("a,F,b,,\nb,F,a,,\n"), &parameters)
+	assert.EqualError(t, err, "a is their own ancestor", "Individuals can't be their own ancestors")
+	_, err = ReadCSV(strings.NewReader("a,M,,,1\nb,F,,a,1\n"), &parameters)
+	assert.Error(t, err, "Children must be in a later generation than their parents")
+	_, err = ReadCSV(strings.NewReader("a,M,x,,\n"), &parameters)
+	assert.Error(t, err, "Parents must be in the pedigree")
+	_, err = ReadCSV(strings.NewReader("a,M,,,\nb,F,a,,\n"), &parameters)
+	assert.EqualError(t, err, "mother a of b has sex M", "Mothers must be female")
+	_, err = ReadCSV(strings.NewReader("a,F,,,\nb,F,,a,\n"), &parameters)
+	assert.EqualError(t, err, "father a of b has sex F", "Fathers must be male")
+	_, err = ReadCSV(strings.NewReader("a,U,,,\nb,F,a,,\nc,M,,a,\n"), &parameters)
+	assert.EqualError(t, err, "father a of c has sex F", "Parents of unknown sex have one role")
+
+	original := setupSim(t)
+	// The fixture doesn't give parents the sex of their role
+	for _, agent := range original.agents {
+		if agent.mother != Unknown {
+			original.agents[agent.mother].sex = FEMALE
+		}
+		if agent.father != Unknown {
+			original.agents[agent.father].sex = MALE
+		}
+	}
+	var gedcom strings.Builder
+	require.NoError(t, original.WriteGEDCOM(&gedcom, nil), "Write GEDCOM")
+	imported, err := ReadGEDCOM(strings.NewReader(gedcom.String()), &parameters)
+	require.NoError(t, err, "Read GEDCOM pedigree")
+	require.Equal(t, len(original.agents), len(imported.agents), "Same number of agents")
+	for i, agent := range imported.agents {
+		assert.Equal(t, original.agents[i].generation, agent.generation, "Same generation")
+		if agent.generation > 0 {
+			assert.Equal(t, original.agents[i].mother, agent.mother, "Same mother")
+			assert.Equal(t, original.agents[i].father, agent.father, "Same father")
+		}
+		if len(agent.children) > 0 {
+			assert.Equal(t, original.agents[i].sex, agent.sex, "Same sex")
+		}
+	}
+	imported.params.Analysis = "N"
+	imported.Analysis()
+	assert.Equal(t, 5, imported.Results().NumAncestors.Count, "Analysis of imported pedigree")
+}
+
+// Calculates inbreeding coefficients directly from the recursive definition
+// of kinship
+func recursiveInbreeding(agents []Agent) []float64 {
+	memo := make(map[[2]int]float64)
+	var kinship func(a, b int) float64
+	kinship = func(a, b int) float64 {
+		if a == Unknown || b == Unknown {
+			return 0.0
+		}
+		if a > b {
+			a, b = b, a
+		}
+		if value, found := memo[[2]int{a, b}]; found {
+			return value
+		}
+		var value float64
+		if a == b {
+			value = (1.0 + kinship(agents[a].mother, agents[a].father)) / 2.0
+		} else {
+			value = (kinship(a, agents[b].mother) + kinship(a, agents[b].father)) / 2.0
+		}
+		memo[[2]int{a, b}] = value
+		return value
+	}
+	inbreeding := make([]float64, len(agents))
+	for i, agent := range agents {
+		inbreeding[i] = kinship(agent.mother, agent.father)
+	}
+	return inbreeding
+}
+
+func TestAgentTable(t *testing.T) {
+	simulation := setupSim(t)
+	inbreeding := inbreedingCoefficients(simulation.agents, len(simulation.agents))
+	assert.Equal(t, 0.0, inbreeding[3], "Parents are unrelated founders")
+	assert.Equal(t, 0.25, inbreeding[5], "Parents are full siblings")
+	assert.Equal(t, 0.375, inbreeding[9], "Parents are inbred full siblings")
+	assert.Equal(t, recursiveInbreeding(simulation.agents), inbreeding, "Matches recursive kinship")
+
+	// A father with children by his daughter and granddaughter
+	parameters := NewParameters()
+	imported, err := ReadCSV(strings.NewReader(
+		"a,M,,,\nb,F,,,\nc,F,b,a,\nd,F,c,a,\ne,M,d,a,\nf,F,c,,\n"), &parameters)
+	require.NoError(t, err, "Read CSV pedigree")
+	inbreeding = inbreedingCoefficients(imported.agents, len(imported.agents))
+	assert.Equal(t, recursiveInbreeding(imported.agents), inbreeding, "Parents from different generations")
+	assert.Equal(t, 0.375, inbreeding[5], "Child of father and granddaughter")
+
+	parameters.NumAgents = 16
+	parameters.Generations = 6
+	parameters.GrowthRate = 1.3
+	simulated, err := NewSimulation(&parameters)
+	require.NoError(t, err, "Valid parameters")
+	simulated.Simulate()
+	assert.InDeltaSlice(t, recursiveInbreeding(simulated.agents),
+		inbreedingCoefficients(simulated.agents, len(simulated.agents)), 1e-12,
+		"Matches recursive kinship in a small population")
+
+	var table strings.Builder
+	require.NoError(t, simulation.WriteAgentsCSV(&table, 3), "Write last generation as CSV")
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	require.Equal(t, 6, len(lines), "Header and a row for each agent in generation 3")
+	assert.Equal(t, "id,generation,sex,mother,father,children,ancestors,inbreeding,genes", lines[0], "Header")
+	assert.Equal(t, "9,3,M,5,7,0,6,0.375,", lines[1], "Row for agent 9")
+
+	table.Reset()
+	require.NoError(t, simulation.WriteAgentsJSONL(&table, -1), "Write every agent as JSON Lines")
+	lines = strings.Split(strings.TrimSpace(table.String()), "\n")
+	require.Equal(t, 14, len(lines), "Line for every agent")
+	assert.Equal(t, `{"id":0,"generation":0,"sex":"M","mother":-1,"father":-1,"children":3,"ancestors":0,"inbreeding":0,"genes":[]}`,
+		lines[0], "Founder with unknown parents")
+
+	assert.Error(t, simulation.WriteAgentsCSV(&table, 4), "Generation not in simulation")
+}
+
+func TestOutput(t *testing.T) {
+	for _, monogamous := range []bool{true, false} {
+		parameters := NewParameters()
+		parameters.NumAgents = 10
+		parameters.Generations = 2
+		parameters.Monogamous = monogamous
+		simulation, err := NewSimulation(&parameters)
+		require.NoError(t, err, "Valid parameters")
+		for i := range simulation.agents {
+			simulation.agents[i].sex = MALE
+		}
+		var out, errOut strings.Builder
+		simulation.SetOutput(&out)
+		simulation.SetErrorOutput(&errOut)
+		simulation.Simulate()
+		assert.Empty(t, out.String(), "Errors kept out of the report")
+		if monogamous {
+			assert.Contains(t, errOut.String(), "Error: No mating pairs for generation 0",
+				"Mating error written to error output")
+		} else {
+			assert.Contains(t, errOut.String(), "Error: No females to make generation 0",
+				"Mating error written to error output")
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	parameters := NewParameters()
+	assert.NoError(t, parameters.Validate(), "Default parameters are valid")
+
+	parameters.NumAgents = 0
+	parameters.MatingK = 1
+	parameters.MutationRate = -0.1
+	parameters.GrowthRate = -1.0
+	parameters.Analysis = "NXC"
+	err := parameters.Validate()
+	require.Error(t, err, "Invalid parameters")
+	for _, field := range []string{"NumAgents", "MatingK", "MutationRate", "GrowthRate", "Analysis"} {
+		assert.Contains(t, err.Error(), field, "Every problem is reported")
+	}
+	_, err = NewSimulation(&parameters)
+	assert.Error(t, err, "Simulation isn't created with invalid parameters")
+	_, err = ReadCSV(strings.NewReader("a,M,,\n"), &parameters)
+	assert.Error(t, err, "Pedigree isn't imported with invalid analysis parameters")
+
+	parameters.Analysis = "NC"
+	assert.Error(t, parameters.Validate(), "Simulation parameters still invalid")
+	assert.NoError(t, parameters.ValidateAnalysis(), "Analysis parameters valid")
+	_, err = ReadCSV(strings.NewReader("a,M,,\n"), &parameters)
+	assert.NoError(t, err, "Simulation parameters aren't used by an import")
+	parameters.HistogramBins = -1
+	assert.ErrorContains(t, parameters.ValidateAnalysis(), "HistogramBins", "Invalid analysis parameter")
+}
+
+func TestQuery(t *testing.T) {
+	simulation := setupSim(t)
+	info, err := simulation.Agent(5)
+	require.NoError(t, err, "Look up agent")
+	assert.Equal(t, AgentInfo{Id: 5, Generation: 2, Sex: FEMALE, Mother: 3, Father: 4, Children: []int{9, 10}},
+		info, "Pedigree links of agent 5")
+	ancestors, err := simulation.Ancestors(9, 0)
+	require.NoError(t, err, "Ancestors")
+	assert.Equal(t, []int{0, 1, 3, 4, 5, 7}, ancestors, "All ancestors")
+	ancestors, _ = simulation.Ancestors(9, 1)
+	assert.Equal(t, []int{5, 7}, ancestors, "Parents")
+	descendants, err := simulation.Descendants(3, 1)
+	require.NoError(t, err, "Descendants")
+	assert.Equal(t, []int{5, 6, 7, 8}, descendants, "Children")
+
+	mrca, err := simulation.MRCA(9, 11)
+	require.NoError(t, err, "MRCA")
+	assert.Equal(t, []int{3, 4}, mrca, "Grandparents shared by cousins")
+	for _, test := range []struct {
+		a, b int
+		name string
+	}{
+		{9, 10, "brother"},
+		{9, 11, "1st cousin"},
+		{5, 9, "mother"},
+		{9, 3, "grandson"},
+		{6, 9, "uncle"},
+		{13, 6, "daughter"},
+		{0, 1, "unrelated"},
+		{4, 4, "self"},
+	} {
+		r, err := simulation.Relationship(test.a, test.b)
+		require.NoError(t, err, "Relationship")
+		assert.Equal(t, test.name, r.Name, "Relationship of %d to %d", test.a, test.b)
+	}
+	assert.Equal(t, "2nd cousin twice removed", relationshipTerm(MALE, 3, 5, 2, true), "Cousins removed")
+	assert.Equal(t, "half great-aunt", relationshipTerm(FEMALE, 1, 3, 1, true), "Half relatives")
+	assert.Equal(t, "half or full sister", relationshipTerm(FEMALE, 1, 1, 1, false), "Other parent unknown")
+	assert.Equal(t, "great-grandfather", relationshipTerm(MALE, 0, 3, 1, false), "Direct ancestor")
+	assert.Equal(t, "half or full sibling", relationshipTerm(UNKNOWN, 1, 1, 1, false), "Sibling of unknown sex")
+	assert.Equal(t, "grandparent", relationshipTerm(UNKNOWN, 0, 2, 2, true), "Ancestor of unknown sex")
+	_, err = simulation.Relationship(9, 14)
+	assert.Error(t, err, "Unknown agent")
+
+	// Half siblings whose mothers are known, and half or full siblings
+	// whose mother is unknown
+	parameters := NewParameters()
+	siblings, err := ReadCSV(strings.NewReader(
+		"f,M,,\nm1,F,,\nm2,F,,\na,M,m1,f\nb,F,m2,f\nc,M,,f\n"), &parameters)
+	require.NoError(t, err, "Read CSV pedigree")
+	r, err := siblings.Relationship(3, 4)
+	require.NoError(t, err, "Relationship")
+	assert.Equal(t, "half brother", r.Name, "Mothers known and different")
+	r, _ = siblings.Relationship(5, 4)
+	assert.Equal(t, "half or full brother", r.Name, "Mother unknown")
+
+	// c1 is a father of a and a great-grandparent of b, and c2 is a
+	// grandparent of both
+	cousins, err := ReadCSV(strings.NewReader(
+		"c1,M,,\nc2,F,,\nr,F,,c1\nm,F,c2,\nq2,F,c2,\nq,M,r,\na,M,m,c1\nb,F,q2,q\n"), &parameters)
+	require.NoError(t, err, "Read CSV pedigree")
+	r, err = cousins.Relationship(6, 7)
+	require.NoError(t, err, "Relationship")
+	assert.Equal(t, Relationship{Common: []int{1}, GenerationsA: 2, GenerationsB: 2,
+		Name: "half or full 1st cousin"}, r, "Most even split of tied common ancestors")
+	r, _ = cousins.Relationship(7, 6)
+	assert.Equal(t, []int{1}, r.Common, "Same common ancestor either way round")
+}
@@ -0,0 +1,213 @@
+package abm
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Calculates inbreeding coefficients from coefficients of kinship, the
+// probability that genes drawn at random from the same locus in two agents
+// are identical by descent in the pedigree. Agents are added in id order,
+// so parents are always added before their children. Kinships are only
+// kept between agents that still have children to add, each of which holds
+// a slot in a table that is reused once its last child has been added, so
+// memory depends on the size of a generation rather than of the whole
+// pedigree.
+type kinship struct {
+	agents []Agent
+	slots  []int       // Slot of each agent, or Unknown if it holds none
+	holder []int       // Agent holding each slot, or Unknown if it's free
+	free   []int       // Slots no agent holds
+	self   []float64   // Kinship of the agent in each slot with itself
+	rows   [][]float64 // Kinships between the agents in each pair of slots
+}
+
+// Returns the inbreeding coefficients of the first n agents
+func inbreedingCoefficients(agents []Agent, n int) []float64 {
+	k := &kinship{agents: agents, slots: make([]int, n)}
+	inbreeding := make([]float64, n)
+	for id := range n {
+		inbreeding[id] = k.add(id)
+	}
+	return inbreeding
+}
+
+// Returns the slot of an agent, or Unknown if the agent is Unknown
+func (k *kinship) slot(id int) int {
+	if id == Unknown {
+		return Unknown
+	}
+	return k.slots[id]
+}
+
+// Returns the kinship of the agents in two slots. Unknown parents are
+// taken to be unrelated to everyone.
+func (k *kinship) of(a, b int) float64 {
+	if a == Unknown || b == Unknown {
+		return 0.0
+	}
+	if a == b {
+		return k.self[a]
+	}
+	if b < len(k.rows[a]) {
+		return k.rows[a][b]
+	}
+	return 0.0
+}
+
+// Adds an agent and returns its inbreeding coefficient, which is the
+// kinship of its parents. The agent's kinship with each agent holding a
+// slot is the mean of that agent's kinship with its parents.
+func (k *kinship) add(id int) float64 {
+	agent := &k.agents[id]
+	mother, father := k.slot(agent.mother), k.slot(agent.father)
+	inbreeding := k.of(mother, father)
+	k.slots[id] = Unknown
+	if len(agent.children) > 0 {
+		var slot int
+		if len(k.free) > 0 {
+			slot = k.free[len(k.free)-1]
+			k.free = k.free[:len(k.free)-1]
+		} else {
+			slot = len(k.holder)
+			k.holder = append(k.holder, Unknown)
+			k.self = append(k.self, 0.0)
+			k.rows = append(k.rows, nil)
+		}
+		row := make([]float64, len(k.holder))
+		for other, holder := range k.holder {
+			if holder == Unknown {
+				continue
+			}
+			value := (k.of(mother, other) + k.of(father, other)) / 2.0
+			row[other] = value
+			for len(k.rows[other]) <= slot {
+				k.rows[other] = append(k.rows[other], 0.0)
+			}
+			k.rows[other][slot] = value
+		}
+		k.slots[id] = slot
+		k.holder[slot] = id
+		k.self[slot] = (1.0 + inbreeding) / 2.0
+		k.rows[slot] = row
+	}
+	for _, parent := range parentsOf(agent) {
+		if slices.Max(k.agents[parent].children) == id {
+			k.holder[k.slots[parent]] = Unknown
+			k.free = append(k.free, k.slots[parent])
+			k.slots[parent] = Unknown
+		}
+	}
+	return inbreeding
+}
+
+// A row of the agent table
+type agentRecord struct {
+	Id         int      `json:"id"`
+	Generation int      `json:"generation"`
+	Sex        string   `json:"sex"`
+	Mother     int      `json:"mother"`
+	Father     int      `json:"father"`
+	Children   int      `json:"children"`
+	Ancestors  int      `json:"ancestors"`
+	Inbreeding float64  `json:"inbreeding"`
+	Genes      []string `json:"genes"`
+}
+
+// Returns the agent table rows for every agent, or for one generation if
+// generation isn't negative
+func (s *Simulation) agentRecords(generation int) ([]agentRecord, error) {
+	if len(s.agents) == 0 {
+		return nil, nil
+	}
+	lastGen := s.agents[len(s.agents)-1].generation
+	start, end := 0, len(s.agents)
+	if generation >= 0 {
+		if generation > lastGen {
+			return nil, fmt.Errorf("no generation %d in simulation", generation)
+		}
+		end = s.genBdrys[generation]
+		if generation > 0 {
+			start = s.genBdrys[generation-1]
+		}
+	}
+	if generation >= 0 {
+		s.setAncestorsGen(generation)
+	} else {
+		s.setAncestorsGen(lastGen)
+	}
+	inbreeding := inbreedingCoefficients(s.agents, end)
+	records := make([]agentRecord, 0, end-start)
+	for id := start; id < end; id++ {
+		agent := &s.agents[id]
+		record := agentRecord{
+			Id:         id,
+			Generation: agent.generation,
+			Sex:        agent.sex.letter(),
+			Mother:     agent.mother,
+			Father:     agent.father,
+			Children:   len(agent.children),
+			Ancestors:  agent.numAncestors,
+			Inbreeding: inbreeding[id],
+			Genes:      make([]string, len(agent.genes)),
+		}
+		for i, g := range agent.genes {
+			record.Genes[i] = g.String()
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Writes a CSV table with one row for every agent, or for the agents of
+// one generation if generation isn't negative. Columns are the id,
+// generation, sex, mother and father (-1 if unknown), number of children,
+// number of distinct ancestors, inbreeding coefficient and the agent's
+// genes separated by spaces.
+func (s *Simulation) WriteAgentsCSV(w io.Writer, generation int) error {
+	records, err := s.agentRecords(generation)
+	if err != nil {
+		return err
+	}
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "generation", "sex", "mother", "father", "children",
+		"ancestors", "inbreeding", "genes"})
+	for _, r := range records {
+		out.Write([]string{
+			strconv.Itoa(r.Id),
+			strconv.Itoa(r.Generation),
+			r.Sex,
+			strconv.Itoa(r.Mother),
+			strconv.Itoa(r.Father),
+			strconv.Itoa(r.Children),
+			strconv.Itoa(r.Ancestors),
+			strconv.FormatFloat(r.Inbreeding, 'g', -1, 64),
+			strings.Join(r.Genes, " "),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Writes the same table as WriteAgentsCSV in JSON Lines format, with one
+// JSON object for each agent and its genes as an array
+func (s *Simulation) WriteAgentsJSONL(w io.Writer, generation int) error {
+	records, err := s.agentRecords(generation)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
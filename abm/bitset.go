@@ -0,0 +1,88 @@
+package abm
+
+import "math/bits"
+
+// A compact set of agent ids. Bit i of word i/64 is set if agent i is in
+// the set. Trailing empty words are never stored so the length of the
+// slice is proportional to the highest id in the set, which for an
+// ancestor set is always lower than the id of the agent that owns it.
+type bitset []uint64
+
+// Adds id to the set, growing the set if needed
+func (b *bitset) add(id int) {
+	word := id / 64
+	if word >= len(*b) {
+		*b = append(*b, make([]uint64, word-len(*b)+1)...)
+	}
+	(*b)[word] |= 1 << uint(id%64)
+}
+
+// Checks if id is in the set
+func (b bitset) has(id int) bool {
+	word := id / 64
+	if word >= len(b) {
+		return false
+	}
+	return b[word]&(1<<uint(id%64)) != 0
+}
+
+// Returns the number of ids in the set
+func (b bitset) count() int {
+	total := 0
+	for _, w := range b {
+		total += bits.OnesCount64(w)
+	}
+	return total
+}
+
+// Returns a new set containing the ids in either set
+func (b bitset) union(other bitset) bitset {
+	if len(b) < len(other) {
+		b, other = other, b
+	}
+	result := make(bitset, len(b))
+	copy(result, b)
+	for i, w := range other {
+		result[i] |= w
+	}
+	return result
+}
+
+// Returns the number of ids in both sets without allocating the intersection
+func (b bitset) intersectCount(other bitset) int {
+	n := min(len(b), len(other))
+	total := 0
+	for i := range n {
+		total += bits.OnesCount64(b[i] & other[i])
+	}
+	return total
+}
+
+// Returns the highest id in both sets or -1 if the sets are disjoint
+func (b bitset) lastCommon(other bitset) int {
+	for i := min(len(b), len(other)) - 1; i >= 0; i-- {
+		if w := b[i] & other[i]; w != 0 {
+			return i*64 + 63 - bits.LeadingZeros64(w)
+		}
+	}
+	return -1
+}
+
+// Calls fn for every id in the set in ascending order
+func (b bitset) each(fn func(id int)) {
+	for i, w := range b {
+		for w != 0 {
+			fn(i*64 + bits.TrailingZeros64(w))
+			w &= w - 1
+		}
+	}
+}
+
+// Returns the ids in the set as a sorted slice
+func (b bitset) toSlice() []int {
+	result := make([]int, 0, b.count())
+	b.each(func(id int) {
+		result = append(result, id)
+	})
+	return result
+}
@@ -0,0 +1,209 @@
+package abm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+// A stretch of chromosome inherited intact from one founder. Positions
+// are in Morgans from the start of the chromosome and a segment covers
+// [Start, End). Haplotype says which of the founder's copies of the
+// chromosome the segment comes from.
+type Segment struct {
+	Start     float64
+	End       float64
+	Founder   int32
+	Haplotype uint8
+}
+
+// Checks if two segments come from the same founder chromosome
+func sameOrigin(a, b Segment) bool {
+	return a.Founder == b.Founder && a.Haplotype == b.Haplotype
+}
+
+// The crossovers in one meiosis. Breaks are the sorted crossover
+// positions and first is the copy (0 or 1) that is transmitted before the
+// first crossover.
+type meiosis struct {
+	breaks []float64
+	first  int
+}
+
+// Returns a random number drawn from the Poisson distribution
+func poisson(lambda float64) int {
+	limit := math.Exp(-lambda)
+	k := 0
+	for p := rand.Float64(); p > limit; p *= rand.Float64() {
+		k++
+	}
+	return k
+}
+
+// Draws the crossovers for one meiosis of a chromosome. The number of
+// crossovers is Poisson distributed with mean equal to the map length.
+func (s *Simulation) newMeiosis() meiosis {
+	length := s.params.ChromosomeLength
+	m := meiosis{
+		breaks: make([]float64, poisson(length)),
+		first:  rand.Intn(2),
+	}
+	for i := range m.breaks {
+		m.breaks[i] = rand.Float64() * length
+	}
+	slices.Sort(m.breaks)
+	return m
+}
+
+// Returns the copy transmitted at the given position
+func (m meiosis) source(position float64) int {
+	crossed, _ := slices.BinarySearch(m.breaks, position)
+	return (m.first + crossed) % 2
+}
+
+// Builds the chromosome transmitted by a meiosis from two copies, taking
+// contiguous stretches from each copy in turn and joining adjacent
+// segments from the same founder chromosome.
+func (m meiosis) recombine(copies [2][]Segment, length float64) []Segment {
+	var result []Segment
+	from := 0.0
+	for k := 0; k <= len(m.breaks); k++ {
+		to := length
+		if k < len(m.breaks) {
+			to = m.breaks[k]
+		}
+		for _, seg := range copies[(m.first+k)%2] {
+			start, end := max(seg.Start, from), min(seg.End, to)
+			if start >= end {
+				continue
+			}
+			seg.Start, seg.End = start, end
+			if n := len(result); n > 0 && sameOrigin(result[n-1], seg) && result[n-1].End == start {
+				result[n-1].End = end
+			} else {
+				result = append(result, seg)
+			}
+		}
+		from = to
+	}
+	return result
+}
+
+// Returns the number of loci on each chromosome. Loci are spread evenly
+// across the chromosomes in order, so the last chromosome may have fewer.
+func (s *Simulation) lociPerChromosome() int {
+	return (s.params.NumGenes + s.params.Chromosomes - 1) / s.params.Chromosomes
+}
+
+// Returns the chromosome and position of a locus
+func (s *Simulation) locusPosition(locus int) (int, float64) {
+	perChromosome := s.lociPerChromosome()
+	chromosome := locus / perChromosome
+	index := locus % perChromosome
+	return chromosome, (float64(index) + 0.5) * s.params.ChromosomeLength / float64(perChromosome)
+}
+
+// Creates the chromosomes of a founder, each copy a single segment
+func (s *Simulation) founderChromosomes(id int) [][]Segment {
+	ploidy := s.ploidy()
+	chromosomes := make([][]Segment, 0, s.params.Chromosomes*ploidy)
+	for range s.params.Chromosomes {
+		for haplotype := range ploidy {
+			chromosomes = append(chromosomes, []Segment{{
+				Start:     0.0,
+				End:       s.params.ChromosomeLength,
+				Founder:   int32(id),
+				Haplotype: uint8(haplotype),
+			}})
+		}
+	}
+	return chromosomes
+}
+
+// Sets the chromosomes and genes of a new agent with linkage between the
+// loci on a chromosome. A diploid parent transmits a recombinant of its
+// two copies of each chromosome. A haploid agent gets a recombinant of its
+// father's and mother's chromosomes, which is the linked counterpart of
+// taking each locus from a random parent.
+func (s *Simulation) inheritChromosomes(agent *Agent) {
+	father := &s.agents[agent.father]
+	mother := &s.agents[agent.mother]
+	ploidy := s.ploidy()
+	length := s.params.ChromosomeLength
+	agent.chromosomes = make([][]Segment, s.params.Chromosomes*ploidy)
+	meioses := make([]meiosis, len(agent.chromosomes))
+	for c := range s.params.Chromosomes {
+		if s.params.Diploid {
+			for i, parent := range [...]*Agent{father, mother} {
+				m := s.newMeiosis()
+				meioses[2*c+i] = m
+				agent.chromosomes[2*c+i] = m.recombine(
+					[2][]Segment{parent.chromosomes[2*c], parent.chromosomes[2*c+1]}, length)
+			}
+		} else {
+			m := s.newMeiosis()
+			meioses[c] = m
+			agent.chromosomes[c] = m.recombine(
+				[2][]Segment{father.chromosomes[c], mother.chromosomes[c]}, length)
+		}
+	}
+	for locus := range s.params.NumGenes {
+		c, position := s.locusPosition(locus)
+		if s.params.Diploid {
+			for i, parent := range [...]*Agent{father, mother} {
+				source := meioses[2*c+i].source(position)
+				s.pass(agent, parent.genes[2*locus+source], newOrigin(i, source))
+			}
+		} else if meioses[c].source(position) == 0 {
+			s.pass(agent, father.genes[locus], newOrigin(0, 0))
+		} else {
+			s.pass(agent, mother.genes[locus], newOrigin(1, 0))
+		}
+	}
+}
+
+// Reports how founder chromosomes have been broken into segments in a
+// slice of agents from one generation
+func analyzeSegments(w io.Writer, agents []Agent) {
+	copies, segments := 0, 0
+	totalLength := 0.0
+	totalFounders := 0
+	for _, agent := range agents {
+		founders := make(map[int32]struct{})
+		for _, chromosome := range agent.chromosomes {
+			copies++
+			segments += len(chromosome)
+			for _, seg := range chromosome {
+				totalLength += seg.End - seg.Start
+				founders[seg.Founder] = struct{}{}
+			}
+		}
+		totalFounders += len(founders)
+	}
+	if copies == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Generation %v: mean segments per chromosome %.3f, mean segment length %.4f M, "+
+		"mean founders per agent %.3f\n", agents[0].generation,
+		float64(segments)/float64(copies), totalLength/float64(segments),
+		float64(totalFounders)/float64(len(agents)))
+}
+
+// Reports segment statistics for each generation
+func (s *Simulation) reportSegments() {
+	if s.params.Chromosomes == 0 {
+		fmt.Fprintln(s.out, "No chromosomes in simulation")
+		return
+	}
+	for gen := range s.genBdrys {
+		start := 0
+		if gen > 0 {
+			start = s.genBdrys[gen-1]
+		}
+		if start < s.genBdrys[gen] {
+			analyzeSegments(s.out, s.agents[start:s.genBdrys[gen]])
+		}
+	}
+}
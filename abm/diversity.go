@@ -0,0 +1,122 @@
+package abm
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Number of frequency classes in the allele frequency spectrum
+const spectrumBins = 10
+
+// Population genetic statistics of one generation. Heterozygosity and the
+// effective number of alleles are means over loci. Fixed is the number of
+// loci with only one allele and Lost the number of alleles present in the
+// previous generation that are now absent. Spectrum counts alleles by
+// frequency in equal width classes, so Spectrum[0] counts alleles with
+// frequency in (0, 0.1] when there are ten classes.
+type DiversityStats struct {
+	Generation             int
+	ExpectedHeterozygosity float64
+	EffectiveAlleles       float64
+	Alleles                int
+	Fixed                  int
+	Lost                   int
+	Spectrum               []int
+}
+
+// Calculates diversity statistics for each generation
+func (s *Simulation) Diversity() []DiversityStats {
+	return s.generationSeries().diversity
+}
+
+// Calculates the diversity statistics of the agents of a generation from
+// their allele counts and those of the previous generation with agents.
+// Returns the statistics and the allele counts.
+func (s *Simulation) diversityStats(gen int, agents []Agent, previous map[allele]int) (DiversityStats, map[allele]int) {
+	counts := make(map[allele]int)
+	for _, agent := range agents {
+		for _, gene := range agent.genes {
+			counts[s.allele(gene)]++
+		}
+	}
+	stats := DiversityStats{
+		Generation: gen,
+		Alleles:    len(counts),
+		Spectrum:   make([]int, spectrumBins),
+	}
+	copies := float64(len(agents) * s.ploidy())
+	homozygosity := make([]float64, s.params.NumGenes)
+	numAlleles := make([]int, s.params.NumGenes)
+	for a, n := range counts {
+		p := float64(n) / copies
+		homozygosity[a.gene.Locus] += p * p
+		numAlleles[a.gene.Locus]++
+		stats.Spectrum[min(int(p*spectrumBins-1e-9), spectrumBins-1)]++
+	}
+	for locus, h := range homozygosity {
+		stats.ExpectedHeterozygosity += 1.0 - h
+		stats.EffectiveAlleles += 1.0 / h
+		if numAlleles[locus] == 1 {
+			stats.Fixed++
+		}
+	}
+	stats.ExpectedHeterozygosity /= float64(s.params.NumGenes)
+	stats.EffectiveAlleles /= float64(s.params.NumGenes)
+	for a := range previous {
+		if _, found := counts[a]; !found {
+			stats.Lost++
+		}
+	}
+	return stats, counts
+}
+
+// Returns the column names of the diversity time series
+func diversityHeader() []string {
+	header := []string{"generation", "expected_heterozygosity", "effective_alleles",
+		"alleles", "fixed", "lost"}
+	for i := range spectrumBins {
+		header = append(header, fmt.Sprintf("afs_%d", i+1))
+	}
+	return header
+}
+
+// Writes the diversity statistics of each generation as CSV
+func (s *Simulation) WriteDiversity(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(diversityHeader()); err != nil {
+		return err
+	}
+	for _, stats := range s.Diversity() {
+		record := []string{
+			strconv.Itoa(stats.Generation),
+			strconv.FormatFloat(stats.ExpectedHeterozygosity, 'f', 6, 64),
+			strconv.FormatFloat(stats.EffectiveAlleles, 'f', 6, 64),
+			strconv.Itoa(stats.Alleles),
+			strconv.Itoa(stats.Fixed),
+			strconv.Itoa(stats.Lost),
+		}
+		for _, n := range stats.Spectrum {
+			record = append(record, strconv.Itoa(n))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Reports the diversity statistics of each generation
+func (s *Simulation) reportDiversity() {
+	if s.params.NumGenes == 0 {
+		fmt.Fprintln(s.out, "No genes in simulation")
+		return
+	}
+	fmt.Fprintln(s.out, "Generation, expected heterozygosity, effective alleles, alleles, fixed, lost, allele frequency spectrum:")
+	for _, stats := range s.Diversity() {
+		fmt.Fprintf(s.out, "%d %.4f %.4f %d %d %d %v\n", stats.Generation, stats.ExpectedHeterozygosity,
+			stats.EffectiveAlleles, stats.Alleles, stats.Fixed, stats.Lost, stats.Spectrum)
+	}
+}
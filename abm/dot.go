@@ -0,0 +1,113 @@
+package abm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Border colours of nodes in DOT graphs, cycled through by generation
+var generationColours = [...]string{
+	"black", "red", "darkgreen", "blue", "orange", "purple", "brown", "cyan4",
+}
+
+// Returns the agents within depth generations of an agent, following the
+// links returned by next, in the order they are first reached. Each agent
+// is included once however many paths lead to it. A depth of 0 or less
+// means no limit.
+func (s *Simulation) reachable(id, depth int, next func(agent *Agent) []int) ([]int, error) {
+	if id < 0 || id >= len(s.agents) {
+		return nil, fmt.Errorf("no agent with id %d", id)
+	}
+	var seen bitset
+	seen.add(id)
+	ids := []int{id}
+	distance := []int{0}
+	for i := 0; i < len(ids); i++ {
+		if depth > 0 && distance[i] >= depth {
+			continue
+		}
+		for _, other := range next(&s.agents[ids[i]]) {
+			if !seen.has(other) {
+				seen.add(other)
+				ids = append(ids, other)
+				distance = append(distance, distance[i]+1)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// Returns the known parents of an agent
+func parentsOf(agent *Agent) []int {
+	var parents []int
+	for _, parent := range [...]int{agent.father, agent.mother} {
+		if parent != Unknown {
+			parents = append(parents, parent)
+		}
+	}
+	return parents
+}
+
+// Returns the children of an agent
+func childrenOf(agent *Agent) []int {
+	return agent.children
+}
+
+// Writes a DOT graph of the given agents with an edge from each parent to
+// each child in the graph
+func (s *Simulation) writeDOT(w io.Writer, title string, ids []int) error {
+	var included bitset
+	for _, id := range ids {
+		included.add(id)
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph pedigree {")
+	fmt.Fprintf(out, "  label=%q;\n", title)
+	fmt.Fprintln(out, "  node [style=filled, penwidth=2];")
+	for _, id := range ids {
+		agent := &s.agents[id]
+		fill := "lightblue"
+		switch agent.sex {
+		case FEMALE:
+			fill = "pink"
+		case UNKNOWN:
+			fill = "lightgrey"
+		}
+		fmt.Fprintf(out, "  %d [label=\"%d\\ngen %d\", fillcolor=%s, color=%s];\n", id, id,
+			agent.generation, fill, generationColours[agent.generation%len(generationColours)])
+	}
+	for _, id := range ids {
+		for _, parent := range parentsOf(&s.agents[id]) {
+			if included.has(parent) {
+				fmt.Fprintf(out, "  %d -> %d;\n", parent, id)
+			}
+		}
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// Writes a Graphviz DOT graph of an agent and its ancestors up to depth
+// generations back, or all its ancestors if depth is 0 or less. Nodes are
+// filled by sex and outlined in a colour for their generation. Ancestors
+// reached through more than one line of descent are drawn once, so
+// pedigree collapse shows as nodes with several paths to the agent.
+func (s *Simulation) WriteAncestryDOT(w io.Writer, id, depth int) error {
+	ids, err := s.reachable(id, depth, parentsOf)
+	if err != nil {
+		return err
+	}
+	return s.writeDOT(w, fmt.Sprintf("Ancestry of agent %d: %d distinct ancestors", id, len(ids)-1), ids)
+}
+
+// Writes a Graphviz DOT graph of an agent and its descendants up to depth
+// generations forward, or all its descendants if depth is 0 or less,
+// drawn as for WriteAncestryDOT
+func (s *Simulation) WriteDescendancyDOT(w io.Writer, id, depth int) error {
+	ids, err := s.reachable(id, depth, childrenOf)
+	if err != nil {
+		return err
+	}
+	return s.writeDOT(w, fmt.Sprintf("Descendancy of agent %d: %d distinct descendants", id, len(ids)-1), ids)
+}
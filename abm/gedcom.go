@@ -0,0 +1,130 @@
+package abm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Pseudo birth year of founders in GEDCOM files
+const gedcomBaseYear = 1000
+
+// Years between generations in GEDCOM pseudo birth dates
+const gedcomGenerationYears = 25
+
+// Returns the agents to export: the given agents and all their ancestors,
+// or every agent if no ids are given
+func (s *Simulation) selectAgents(ids []int) (bitset, error) {
+	var selected bitset
+	if len(ids) == 0 {
+		for id := range s.agents {
+			selected.add(id)
+		}
+		return selected, nil
+	}
+	queue := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if id < 0 || id >= len(s.agents) {
+			return nil, fmt.Errorf("no agent with id %d", id)
+		}
+		if !selected.has(id) {
+			selected.add(id)
+			queue = append(queue, id)
+		}
+	}
+	for sp := 0; sp < len(queue); sp++ {
+		for _, parent := range parentsOf(&s.agents[queue[sp]]) {
+			if !selected.has(parent) {
+				selected.add(parent)
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return selected, nil
+}
+
+// A couple and their children in a GEDCOM file
+type gedcomFamily struct {
+	father   int
+	mother   int
+	children []int
+}
+
+// Writes agents as a GEDCOM 5.5.1 file that can be opened in family tree
+// software. If ids are given only those agents and their ancestors are
+// written, otherwise every agent is. Each agent's birth year is a pseudo
+// date calculated from its generation. Families are made from each
+// couple's children, leaving out parents that are unknown.
+func (s *Simulation) WriteGEDCOM(w io.Writer, ids []int) error {
+	selected, err := s.selectAgents(ids)
+	if err != nil {
+		return err
+	}
+	var families []gedcomFamily
+	familyOf := make(map[[2]int]int)
+	childOf := make(map[int]int)
+	spouseOf := make(map[int][]int)
+	selected.each(func(id int) {
+		agent := &s.agents[id]
+		if agent.father == Unknown && agent.mother == Unknown {
+			return
+		}
+		couple := [2]int{agent.father, agent.mother}
+		f, found := familyOf[couple]
+		if !found {
+			f = len(families)
+			familyOf[couple] = f
+			families = append(families, gedcomFamily{father: agent.father, mother: agent.mother})
+			for _, parent := range parentsOf(agent) {
+				spouseOf[parent] = append(spouseOf[parent], f)
+			}
+		}
+		families[f].children = append(families[f].children, id)
+		childOf[id] = f
+	})
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "0 HEAD")
+	fmt.Fprintln(out, "1 SOUR ABM")
+	fmt.Fprintln(out, "2 NAME Agent based model of ancestry")
+	fmt.Fprintln(out, "1 SUBM @U1@")
+	fmt.Fprintln(out, "1 GEDC")
+	fmt.Fprintln(out, "2 VERS 5.5.1")
+	fmt.Fprintln(out, "2 FORM LINEAGE-LINKED")
+	fmt.Fprintln(out, "1 CHAR UTF-8")
+	fmt.Fprintln(out, "0 @U1@ SUBM")
+	fmt.Fprintf(out, "1 NAME Simulation %d\n", s.id)
+	selected.each(func(id int) {
+		agent := &s.agents[id]
+		fmt.Fprintf(out, "0 @I%d@ INDI\n", id)
+		if s.params.Surnames != "" {
+			fmt.Fprintf(out, "1 NAME Agent %d /S%d/\n", id, agent.surname)
+		} else {
+			fmt.Fprintf(out, "1 NAME Agent %d\n", id)
+		}
+		fmt.Fprintf(out, "1 SEX %s\n", agent.sex.letter())
+		fmt.Fprintln(out, "1 BIRT")
+		fmt.Fprintf(out, "2 DATE %d\n", gedcomBaseYear+agent.generation*gedcomGenerationYears)
+		fmt.Fprintf(out, "1 NOTE Generation %d\n", agent.generation)
+		if f, found := childOf[id]; found {
+			fmt.Fprintf(out, "1 FAMC @F%d@\n", f)
+		}
+		for _, f := range spouseOf[id] {
+			fmt.Fprintf(out, "1 FAMS @F%d@\n", f)
+		}
+	})
+	for f, family := range families {
+		fmt.Fprintf(out, "0 @F%d@ FAM\n", f)
+		if family.father != Unknown {
+			fmt.Fprintf(out, "1 HUSB @I%d@\n", family.father)
+		}
+		if family.mother != Unknown {
+			fmt.Fprintf(out, "1 WIFE @I%d@\n", family.mother)
+		}
+		for _, child := range family.children {
+			fmt.Fprintf(out, "1 CHIL @I%d@\n", child)
+		}
+	}
+	fmt.Fprintln(out, "0 TRLR")
+	return out.Flush()
+}
@@ -0,0 +1,215 @@
+package abm
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// A gene at one locus. Founder is the id of the founder agent the gene
+// descends from and Locus is the number of the gene in the genome. In
+// diploid simulations Haplotype says which of the founder's two copies of
+// the locus the gene descends from. Lineage identifies the most recent
+// mutation in the gene's history and is 0 if the gene has never mutated,
+// so genes are identical if all fields are equal. The alleles genes carry
+// also depend on the mutation model. Variant marks founder genes that
+// carry the focal variant, which affects fitness at selected loci.
+type Gene struct {
+	Founder   int32
+	Locus     int32
+	Lineage   int32
+	Haplotype uint8
+	Variant   bool
+}
+
+// Formats a gene as founder-locus, with /haplotype after the founder for
+// a founder's second copy and .lineage at the end if it has mutated
+func (g Gene) String() string {
+	founder := fmt.Sprint(g.Founder)
+	if g.Haplotype != 0 {
+		founder += fmt.Sprintf("/%d", g.Haplotype)
+	}
+	if g.Lineage == 0 {
+		return fmt.Sprintf("%s-%d", founder, g.Locus)
+	}
+	return fmt.Sprintf("%s-%d.%d", founder, g.Locus, g.Lineage)
+}
+
+// Checks if two genes descend from the same founder gene, ignoring mutations
+func identicalByDescent(a, b Gene) bool {
+	return a.Founder == b.Founder && a.Locus == b.Locus && a.Haplotype == b.Haplotype
+}
+
+// Returns the number of copies of each locus an agent carries
+func (s *Simulation) ploidy() int {
+	if s.params.Diploid {
+		return 2
+	}
+	return 1
+}
+
+// Creates the genes of a founder. Genes are stored by locus, with the
+// copies of a locus next to each other.
+func (s *Simulation) founderGenes(id int) []Gene {
+	ploidy := s.ploidy()
+	genes := make([]Gene, 0, s.params.NumGenes*ploidy)
+	for locus := range s.params.NumGenes {
+		for haplotype := range ploidy {
+			genes = append(genes, Gene{
+				Founder:   int32(id),
+				Locus:     int32(locus),
+				Haplotype: uint8(haplotype),
+				Variant:   s.params.VariantFrequency > 0.0 && rand.Float64() < s.params.VariantFrequency,
+			})
+		}
+	}
+	return genes
+}
+
+// Sets the genes of a new agent from its parents. If there are
+// chromosomes, genes on the same chromosome are linked. Otherwise a
+// haploid agent gets each locus from a randomly chosen parent. A diploid
+// agent gets its first copy of each locus from its father and its second
+// from its mother, each parent passing on one of its two copies at random.
+func (s *Simulation) inheritGenes(agent *Agent) {
+	n := s.params.NumGenes * s.ploidy()
+	agent.genes = make([]Gene, 0, n)
+	agent.origins = make([]origin, 0, n)
+	if s.params.Chromosomes > 0 {
+		s.inheritChromosomes(agent)
+		return
+	}
+	father := &s.agents[agent.father]
+	mother := &s.agents[agent.mother]
+	for locus := range s.params.NumGenes {
+		if s.params.Diploid {
+			for i, parent := range [...]*Agent{father, mother} {
+				c := rand.Intn(2)
+				s.pass(agent, parent.genes[2*locus+c], newOrigin(i, c))
+			}
+		} else if rand.Float64() < 0.5 {
+			s.pass(agent, father.genes[locus], newOrigin(0, 0))
+		} else {
+			s.pass(agent, mother.genes[locus], newOrigin(1, 0))
+		}
+	}
+}
+
+// Records where an agent's gene came from. Bit 0 is set if the gene came
+// from the mother rather than the father and bit 1 is set if it was the
+// parent's second copy of the locus.
+type origin uint8
+
+// Creates an origin from the parent (0 for father, 1 for mother) and the
+// copy of the locus in the parent
+func newOrigin(parent, parentCopy int) origin {
+	return origin(parent | parentCopy<<1)
+}
+
+// Returns 0 if the gene came from the father and 1 if from the mother
+func (o origin) parent() int {
+	return int(o & 1)
+}
+
+// Returns the parent's copy of the locus the gene came from
+func (o origin) parentCopy() int {
+	return int(o >> 1)
+}
+
+// Appends a gene passed on by a parent to an agent's genes
+func (s *Simulation) pass(agent *Agent, g Gene, o origin) {
+	agent.genes = append(agent.genes, s.transmit(g, agent))
+	agent.origins = append(agent.origins, o)
+}
+
+// Reports how often the two copies of a locus in diploid agents are the
+// same allele (homozygous), different alleles (heterozygous), and descended
+// from the same founder gene regardless of mutation (identical by descent).
+func (s *Simulation) reportZygosity(w io.Writer, agents []Agent) {
+	loci, homozygous, ibd := 0, 0, 0
+	for _, agent := range agents {
+		for i := 0; i+1 < len(agent.genes); i += 2 {
+			a, b := agent.genes[i], agent.genes[i+1]
+			loci++
+			if s.allele(a) == s.allele(b) {
+				homozygous++
+			}
+			if identicalByDescent(a, b) {
+				ibd++
+			}
+		}
+	}
+	if loci == 0 {
+		return
+	}
+	homozygosity := float64(homozygous) / float64(loci)
+	fmt.Fprintf(w, "Homozygosity, heterozygosity: %.4f %.4f\n", homozygosity, 1.0-homozygosity)
+	fmt.Fprintf(w, "Proportion of loci identical by descent from a founder gene: %.4f\n",
+		float64(ibd)/float64(loci))
+}
+
+// Returns the set of ancestors that passed on at least one of an agent's
+// genes, found by following each gene back through the parents it was
+// inherited from.
+func (s *Simulation) geneticAncestors(id int) bitset {
+	var ancestors bitset
+	ploidy := s.ploidy()
+	for slot := range s.agents[id].origins {
+		locus := slot / ploidy
+		agent := &s.agents[id]
+		for agent.generation > 0 {
+			o := agent.origins[slot]
+			parent := agent.father
+			if o.parent() == 1 {
+				parent = agent.mother
+			}
+			ancestors.add(parent)
+			agent = &s.agents[parent]
+			slot = locus*ploidy + o.parentCopy()
+		}
+	}
+	return ancestors
+}
+
+// Reports, for each number of generations back, how many genealogical
+// ancestors agents in the last generation have and how many of those
+// contributed any genes to them. Going back far enough, most genealogical
+// ancestors leave no genes in their descendants. Simulations without genes,
+// such as imported pedigrees, have no genetic ancestors to report.
+func (s *Simulation) reportGeneticAncestors() {
+	if s.params.NumGenes == 0 {
+		fmt.Fprintln(s.out, "No genes in simulation, so genetic ancestors are unknown")
+		return
+	}
+	lastGen := s.agents[len(s.agents)-1].generation
+	start := s.genBdrys[lastGen-1]
+	genealogical := make([]int, lastGen+1)
+	genetic := make([]int, lastGen+1)
+	for id := start; id < len(s.agents); id++ {
+		s.agents[id].ancestors.each(func(ancestor int) {
+			genealogical[lastGen-s.agents[ancestor].generation]++
+		})
+		s.geneticAncestors(id).each(func(ancestor int) {
+			genetic[lastGen-s.agents[ancestor].generation]++
+		})
+	}
+	n := float64(len(s.agents) - start)
+	fmt.Fprintln(s.out, "Generations back, mean genealogical ancestors, mean genetic ancestors, proportion genetic:")
+	ghostDepth := 0
+	for depth := 1; depth <= lastGen; depth++ {
+		proportion := 0.0
+		if genealogical[depth] > 0 {
+			proportion = float64(genetic[depth]) / float64(genealogical[depth])
+		}
+		if ghostDepth == 0 && proportion < 0.5 {
+			ghostDepth = depth
+		}
+		fmt.Fprintf(s.out, "%d %.2f %.2f %.4f\n", depth, float64(genealogical[depth])/n,
+			float64(genetic[depth])/n, proportion)
+	}
+	if ghostDepth == 1 {
+		fmt.Fprintln(s.out, "Most genealogical ancestors contribute no genes from 1 generation back")
+	} else if ghostDepth > 1 {
+		fmt.Fprintf(s.out, "Most genealogical ancestors contribute no genes from %d generations back\n", ghostDepth)
+	}
+}
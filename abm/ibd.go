@@ -0,0 +1,166 @@
+package abm
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Returns the stretches where two chromosome copies have segments from the
+// same founder chromosome, which are identical by descent. Both copies
+// must have sorted, non-overlapping segments.
+func sharedSegments(a, b []Segment) []Segment {
+	var shared []Segment
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		start, end := max(a[i].Start, b[j].Start), min(a[i].End, b[j].End)
+		if start < end && sameOrigin(a[i], b[j]) {
+			seg := a[i]
+			seg.Start, seg.End = start, end
+			shared = append(shared, seg)
+		}
+		if a[i].End < b[j].End {
+			i++
+		} else {
+			j++
+		}
+	}
+	return shared
+}
+
+// Merges overlapping or touching segments into single stretches,
+// regardless of origin, and returns them sorted by start
+func mergeSegments(segments []Segment) []Segment {
+	slices.SortFunc(segments, func(x, y Segment) int {
+		if x.Start < y.Start {
+			return -1
+		}
+		if x.Start > y.Start {
+			return 1
+		}
+		return 0
+	})
+	var merged []Segment
+	for _, seg := range segments {
+		if n := len(merged); n > 0 && seg.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, seg.End)
+		} else {
+			merged = append(merged, seg)
+		}
+	}
+	return merged
+}
+
+// Returns the number and total length of the segments two agents share
+// identical by descent. Any copy of a chromosome in one agent is compared
+// with any copy of the same chromosome in the other, and segments shorter
+// than the IBDMinLength parameter are ignored.
+func (s *Simulation) ibdSharing(a, b *Agent) (int, float64) {
+	ploidy := s.ploidy()
+	count := 0
+	length := 0.0
+	for c := range s.params.Chromosomes {
+		var shared []Segment
+		for _, x := range a.chromosomes[c*ploidy : (c+1)*ploidy] {
+			for _, y := range b.chromosomes[c*ploidy : (c+1)*ploidy] {
+				shared = append(shared, sharedSegments(x, y)...)
+			}
+		}
+		for _, seg := range mergeSegments(shared) {
+			if seg.End-seg.Start >= s.params.IBDMinLength {
+				count++
+				length += seg.End - seg.Start
+			}
+		}
+	}
+	return count, length
+}
+
+// Returns a name for the relationship of two agents in the same
+// generation whose most recent common ancestors are the given number of
+// generations back
+func relationshipName(generationsBack int) string {
+	switch generationsBack {
+	case 0:
+		return "unrelated"
+	case 1:
+		return "siblings"
+	default:
+		return ordinal(generationsBack-1) + " cousins"
+	}
+}
+
+// Returns the English ordinal of a positive number, e.g. 2nd
+func ordinal(n int) string {
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// IBD sharing totals for pairs of agents with the same relationship
+type ibdGroup struct {
+	pairs    int
+	sharing  int
+	segments int
+	length   float64
+}
+
+// Reports the IBD segments shared by pairs of agents in the last
+// generation, grouped by how many generations back their most recent
+// common ancestors are
+func (s *Simulation) reportIBD() {
+	if s.params.Chromosomes == 0 {
+		fmt.Fprintln(s.out, "No chromosomes in simulation")
+		return
+	}
+	lastGen := s.agents[len(s.agents)-1].generation
+	start := s.genBdrys[lastGen-1]
+	workers := s.pairWorkers(len(s.agents) - start)
+	partial := make([][]ibdGroup, workers)
+	for w := range partial {
+		partial[w] = make([]ibdGroup, lastGen+1)
+	}
+	sampled := s.visitPairs(start, len(s.agents), workers, func(w int, a, b *Agent) {
+		back := 0
+		if common := a.ancestors.lastCommon(b.ancestors); common >= 0 {
+			back = a.generation - s.agents[common].generation
+		}
+		count, length := s.ibdSharing(a, b)
+		group := &partial[w][back]
+		group.pairs++
+		group.segments += count
+		group.length += length
+		if count > 0 {
+			group.sharing++
+		}
+	})
+	groups := make([]ibdGroup, lastGen+1)
+	for _, groupsOfWorker := range partial {
+		for back, group := range groupsOfWorker {
+			groups[back].pairs += group.pairs
+			groups[back].sharing += group.sharing
+			groups[back].segments += group.segments
+			groups[back].length += group.length
+		}
+	}
+	if sampled {
+		fmt.Fprintf(s.out, "IBD sharing estimated from %d sampled pairs\n", s.params.PairSample)
+	}
+	fmt.Fprintln(s.out, "Generations to common ancestor, relationship, pairs, proportion sharing IBD, mean segments, mean total length (M):")
+	for back, group := range groups {
+		if group.pairs == 0 {
+			continue
+		}
+		n := float64(group.pairs)
+		fmt.Fprintf(s.out, "%d %s %d %.4f %.3f %.4f\n", back, relationshipName(back), group.pairs,
+			float64(group.sharing)/n, float64(group.segments)/n, group.length/n)
+	}
+}
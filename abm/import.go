@@ -0,0 +1,339 @@
+package abm
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// An individual read from a pedigree file. Mother and father are the keys
+// of the parents, or empty if unknown. Generation is -1 if it isn't given
+// and has to be calculated from the parents.
+type pedigreeRecord struct {
+	key        string
+	sex        Sex
+	mother     string
+	father     string
+	generation int
+}
+
+// Builds a simulation from the individuals in a pedigree. Individuals
+// whose generation isn't given are one generation after their latest
+// parent, or in generation 0 if no parents are known. Missing parents are
+// Unknown, so an individual's ancestry is the part of it that is known.
+// Individuals whose sex is unknown take it from their role as a parent, or
+// keep the UNKNOWN sex. Imported pedigrees carry no genetic data, so the
+// simulation has no genes or chromosomes. Only the analysis parameters are
+// checked because the simulation parameters aren't used.
+func newPedigreeSimulation(records []pedigreeRecord, parameters *Parameters) (*Simulation, error) {
+	if err := parameters.ValidateAnalysis(); err != nil {
+		return nil, err
+	}
+	index := make(map[string]int, len(records))
+	for i, record := range records {
+		if record.key == "" {
+			return nil, fmt.Errorf("individual %d has no id", i+1)
+		}
+		if _, found := index[record.key]; found {
+			return nil, fmt.Errorf("duplicate id %s", record.key)
+		}
+		index[record.key] = i
+	}
+	mothers := make([]int, len(records))
+	fathers := make([]int, len(records))
+	for i, record := range records {
+		mothers[i], fathers[i] = Unknown, Unknown
+		if record.mother != "" {
+			mother, found := index[record.mother]
+			if !found {
+				return nil, fmt.Errorf("unknown mother %s of %s", record.mother, record.key)
+			}
+			mothers[i] = mother
+			if records[mother].sex == UNKNOWN {
+				records[mother].sex = FEMALE
+			} else if records[mother].sex != FEMALE {
+				return nil, fmt.Errorf("mother %s of %s has sex M", record.mother, record.key)
+			}
+		}
+		if record.father != "" {
+			father, found := index[record.father]
+			if !found {
+				return nil, fmt.Errorf("unknown father %s of %s", record.father, record.key)
+			}
+			fathers[i] = father
+			if records[father].sex == UNKNOWN {
+				records[father].sex = MALE
+			} else if records[father].sex != MALE {
+				return nil, fmt.Errorf("father %s of %s has sex F", record.father, record.key)
+			}
+		}
+	}
+
+	// Calculate generations, checking that nobody is their own ancestor
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(records))
+	generations := make([]int, len(records))
+	var generationOf func(i int) (int, error)
+	generationOf = func(i int) (int, error) {
+		switch state[i] {
+		case visiting:
+			return 0, fmt.Errorf("%s is their own ancestor", records[i].key)
+		case visited:
+			return generations[i], nil
+		}
+		state[i] = visiting
+		latest := -1
+		for _, parent := range [...]int{mothers[i], fathers[i]} {
+			if parent == Unknown {
+				continue
+			}
+			gen, err := generationOf(parent)
+			if err != nil {
+				return 0, err
+			}
+			latest = max(latest, gen)
+		}
+		generation := latest + 1
+		if given := records[i].generation; given >= 0 {
+			if given <= latest {
+				return 0, fmt.Errorf("generation %d of %s is not after its parents' generations",
+					given, records[i].key)
+			}
+			generation = given
+		}
+		state[i] = visited
+		generations[i] = generation
+		return generation, nil
+	}
+	for i := range records {
+		if _, err := generationOf(i); err != nil {
+			return nil, err
+		}
+	}
+
+	// Agents are sorted by generation so ids are indices
+	order := make([]int, len(records))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return generations[a] - generations[b]
+	})
+	newIds := make([]int, len(order))
+	for id, i := range order {
+		newIds[i] = id
+	}
+	agents := make([]Agent, len(order))
+	for id, i := range order {
+		agents[id] = Agent{
+			id:         id,
+			generation: generations[i],
+			sex:        records[i].sex,
+			mother:     Unknown,
+			father:     Unknown,
+		}
+		if mothers[i] != Unknown {
+			agents[id].mother = newIds[mothers[i]]
+			agents[agents[id].mother].children = append(agents[agents[id].mother].children, id)
+		}
+		if fathers[i] != Unknown {
+			agents[id].father = newIds[fathers[i]]
+			agents[agents[id].father].children = append(agents[agents[id].father].children, id)
+		}
+	}
+
+	p := *parameters
+	p.setDefaults()
+	p.NumAgents = len(agents)
+	p.NumGenes = 0
+	p.Chromosomes = 0
+	p.Surnames = ""
+	lastGen := 0
+	if len(agents) > 0 {
+		lastGen = agents[len(agents)-1].generation
+	}
+	p.Generations = lastGen
+	simulation := &Simulation{id: p.SimulationId, agents: agents, params: p, out: os.Stdout, errOut: os.Stderr}
+	simulation.SetGenBdrys()
+	simulation.setCurrGen(lastGen)
+	return simulation, nil
+}
+
+// Parses the sex of an individual, which is unknown if empty or U
+func parseSex(value string) (Sex, error) {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "M", "MALE":
+		return MALE, nil
+	case "F", "FEMALE":
+		return FEMALE, nil
+	case "", "U":
+		return UNKNOWN, nil
+	}
+	return UNKNOWN, fmt.Errorf("unknown sex %q", value)
+}
+
+// Reads a pedigree from a CSV file with the columns id, sex, mother,
+// father and optionally generation, and an optional header row. Sex is M
+// or F, or empty or U if unknown. Unknown parents are empty, - or NA, and
+// an empty generation is calculated from the parents. The ids can be any
+// text. Agents in the simulation are numbered by generation from 0. The
+// parameters set the analyses run on the pedigree.
+func ReadCSV(r io.Reader, parameters *Parameters) (*Simulation, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	var records []pedigreeRecord
+	for row := 1; ; row++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row == 1 && strings.EqualFold(strings.TrimSpace(fields[0]), "id") {
+			continue
+		}
+		if len(fields) < 4 || len(fields) > 5 {
+			return nil, fmt.Errorf("row %d: expected 4 or 5 columns but found %d", row, len(fields))
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+			if i == 2 || i == 3 {
+				if fields[i] == "-" || fields[i] == "NA" {
+					fields[i] = ""
+				}
+			}
+		}
+		record := pedigreeRecord{
+			key:        fields[0],
+			mother:     fields[2],
+			father:     fields[3],
+			generation: -1,
+		}
+		record.sex, err = parseSex(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		if len(fields) == 5 && fields[4] != "" {
+			record.generation, err = strconv.Atoi(fields[4])
+			if err != nil || record.generation < 0 {
+				return nil, fmt.Errorf("row %d: invalid generation %q", row, fields[4])
+			}
+		}
+		records = append(records, record)
+	}
+	return newPedigreeSimulation(records, parameters)
+}
+
+// Splits a GEDCOM line into its level, optional cross reference id, tag
+// and value
+func parseGEDCOMLine(line string) (int, string, string, string, error) {
+	fields := strings.SplitN(line, " ", 2)
+	level, err := strconv.Atoi(fields[0])
+	if err != nil || len(fields) < 2 {
+		return 0, "", "", "", errors.New("invalid GEDCOM line")
+	}
+	rest := fields[1]
+	xref := ""
+	if strings.HasPrefix(rest, "@") {
+		fields = strings.SplitN(rest, " ", 2)
+		if len(fields) < 2 {
+			return 0, "", "", "", errors.New("GEDCOM record has no tag")
+		}
+		xref, rest = fields[0], fields[1]
+	}
+	tag, value, _ := strings.Cut(rest, " ")
+	return level, xref, tag, value, nil
+}
+
+// A GEDCOM family record
+type gedcomFamilyRecord struct {
+	husband  string
+	wife     string
+	children []string
+}
+
+// Reads a pedigree from a GEDCOM file. Each individual becomes an agent
+// whose parents are the husband and wife of the family it is a child of.
+// If an individual is a child in more than one family the first is used.
+// Generations are calculated from the parents as described for ReadCSV.
+// Records other than individuals and families are ignored.
+func ReadGEDCOM(r io.Reader, parameters *Parameters) (*Simulation, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var records []pedigreeRecord
+	var families []gedcomFamilyRecord
+	record, family := -1, -1
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+		level, xref, tag, value, err := parseGEDCOMLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if level == 0 {
+			record, family = -1, -1
+			switch tag {
+			case "INDI":
+				record = len(records)
+				records = append(records, pedigreeRecord{key: xref, sex: UNKNOWN, generation: -1})
+			case "FAM":
+				family = len(families)
+				families = append(families, gedcomFamilyRecord{})
+			}
+			continue
+		}
+		if level != 1 {
+			continue
+		}
+		switch {
+		case record >= 0 && tag == "SEX":
+			records[record].sex, err = parseSex(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+		case family >= 0 && tag == "HUSB":
+			families[family].husband = value
+		case family >= 0 && tag == "WIFE":
+			families[family].wife = value
+		case family >= 0 && tag == "CHIL":
+			families[family].children = append(families[family].children, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	index := make(map[string]int, len(records))
+	for i, record := range records {
+		index[record.key] = i
+	}
+	hasParents := make([]bool, len(records))
+	for _, family := range families {
+		for _, child := range family.children {
+			i, found := index[child]
+			if !found {
+				return nil, fmt.Errorf("unknown child %s in family", child)
+			}
+			if hasParents[i] {
+				continue
+			}
+			hasParents[i] = true
+			records[i].father = family.husband
+			records[i].mother = family.wife
+		}
+	}
+	return newPedigreeSimulation(records, parameters)
+}
@@ -0,0 +1,235 @@
+package abm
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// A uniparental lineage, such as a Y chromosome or mitochondrial DNA.
+// Founder is the id of the founder the lineage descends from and Mutation
+// identifies the most recent mutation on the lineage, 0 if none.
+type Haplogroup struct {
+	Founder  int32
+	Mutation int32
+}
+
+// Formats a haplogroup as founder, followed by .mutation if it has mutated
+func (h Haplogroup) String() string {
+	if h.Mutation == 0 {
+		return fmt.Sprint(h.Founder)
+	}
+	return fmt.Sprintf("%d.%d", h.Founder, h.Mutation)
+}
+
+// Starts the Y and mitochondrial lineages of a founder. Only males carry
+// a Y chromosome.
+func founderLineages(agent *Agent) {
+	if agent.sex == MALE {
+		agent.yLineage = Haplogroup{Founder: int32(agent.id)}
+	}
+	agent.mtLineage = Haplogroup{Founder: int32(agent.id)}
+}
+
+// Passes the Y lineage from father to son and the mitochondrial lineage
+// from mother to child
+func (s *Simulation) inheritLineages(agent *Agent) {
+	if agent.sex == MALE {
+		agent.yLineage = s.transmitLineage(s.agents[agent.father].yLineage)
+	}
+	agent.mtLineage = s.transmitLineage(s.agents[agent.mother].mtLineage)
+}
+
+// Passes a lineage from parent to child, mutating it at the uniparental
+// mutation rate
+func (s *Simulation) transmitLineage(h Haplogroup) Haplogroup {
+	rate := s.params.UniparentalMutationRate
+	if rate > 0.0 && rand.Float64() < rate {
+		s.numLineageMutations++
+		h.Mutation = s.numLineageMutations
+	}
+	return h
+}
+
+// Follows a uniparental line back from the given agents until the lines
+// meet. Returns the id of the most recent common ancestor on the line and
+// true, or false if a line ends at an unknown parent without meeting.
+func (s *Simulation) uniparentalMRCA(ids []int, parent func(a *Agent) int) (int, bool) {
+	current := make(map[int]struct{})
+	for _, id := range ids {
+		current[id] = struct{}{}
+	}
+	for len(current) > 1 {
+		next := make(map[int]struct{})
+		for id := range current {
+			p := parent(&s.agents[id])
+			if p == Unknown {
+				return 0, false
+			}
+			next[p] = struct{}{}
+		}
+		current = next
+	}
+	for id := range current {
+		return id, true
+	}
+	return 0, false
+}
+
+// Returns the lineage report row of the agents of a generation
+func lineageRow(gen int, agents []Agent) string {
+	yFounders := make(map[int32]struct{})
+	yGroups := make(map[Haplogroup]struct{})
+	mtFounders := make(map[int32]struct{})
+	mtGroups := make(map[Haplogroup]struct{})
+	for _, agent := range agents {
+		if agent.sex == MALE {
+			yFounders[agent.yLineage.Founder] = struct{}{}
+			yGroups[agent.yLineage] = struct{}{}
+		}
+		mtFounders[agent.mtLineage.Founder] = struct{}{}
+		mtGroups[agent.mtLineage] = struct{}{}
+	}
+	return fmt.Sprintf("%d %d %d %d %d", gen, len(yFounders), len(yGroups), len(mtFounders), len(mtGroups))
+}
+
+// Reports how many founder Y and mitochondrial lineages survive in each
+// generation, and the Y-chromosomal and mitochondrial most recent common
+// ancestors of the last generation
+func (s *Simulation) reportLineages() {
+	fmt.Fprintln(s.out, "Generation, founder Y lineages, Y haplogroups, founder mt lineages, mt haplogroups:")
+	for _, row := range s.generationSeries().lineages {
+		fmt.Fprintln(s.out, row)
+	}
+
+	lastGen := s.agents[len(s.agents)-1].generation
+	start := s.genBdrys[lastGen-1]
+	var males, everyone []int
+	for id := start; id < len(s.agents); id++ {
+		if s.agents[id].sex == MALE {
+			males = append(males, id)
+		}
+		everyone = append(everyone, id)
+	}
+	if len(males) == 0 {
+		fmt.Fprintln(s.out, "No males in last generation")
+	} else if id, found := s.uniparentalMRCA(males, func(a *Agent) int { return a.father }); found {
+		fmt.Fprintf(s.out, "Y-chromosomal MRCA: agent %d in generation %d\n", id, s.agents[id].generation)
+	} else {
+		fmt.Fprintln(s.out, "No Y-chromosomal MRCA since the founders")
+	}
+	if id, found := s.uniparentalMRCA(everyone, func(a *Agent) int { return a.mother }); found {
+		fmt.Fprintf(s.out, "Mitochondrial MRCA: agent %d in generation %d\n", id, s.agents[id].generation)
+	} else {
+		fmt.Fprintln(s.out, "No mitochondrial MRCA since the founders")
+	}
+}
+
+// Number of generations used to approximate eventual extinction
+const extinctionGenerations = 10000
+
+// Returns the sex that passes on surnames
+func (s *Simulation) surnameSex() Sex {
+	if s.params.Surnames == "mother" {
+		return FEMALE
+	}
+	return MALE
+}
+
+// Passes the surname from father or mother to child
+func (s *Simulation) inheritSurname(agent *Agent) {
+	switch s.params.Surnames {
+	case "father":
+		agent.surname = s.agents[agent.father].surname
+	case "mother":
+		agent.surname = s.agents[agent.mother].surname
+	}
+}
+
+// Returns the probabilities that a branching process with the given
+// offspring distribution is extinct by each generation up to generations.
+// probs[k] is the probability of having k offspring.
+func extinctionProbabilities(probs []float64, generations int) []float64 {
+	q := make([]float64, generations+1)
+	for n := 1; n <= generations; n++ {
+		// Evaluate the generating function at q[n-1] with Horner's method
+		f := 0.0
+		for k := len(probs) - 1; k >= 0; k-- {
+			f = f*q[n-1] + probs[k]
+		}
+		q[n] = f
+	}
+	return q
+}
+
+// Reports how many surnames survive each generation and how surname
+// survival compares with a Galton-Watson branching process using the
+// observed distribution of same sex children of surname bearers
+func (s *Simulation) reportSurnames() {
+	if s.params.Surnames == "" {
+		fmt.Fprintln(s.out, "Surnames not inherited in simulation")
+		return
+	}
+	sex := s.surnameSex()
+	lastGen := s.agents[len(s.agents)-1].generation
+	series := s.generationSeries()
+	fmt.Fprintln(s.out, "Generation, surnames, surnames that can be passed on:")
+	for gen, row := range series.surnames {
+		fmt.Fprintln(s.out, row)
+		if gen == lastGen {
+			surnames := make(map[int32]int)
+			for _, agent := range s.agents[s.genBdrys[lastGen-1]:] {
+				surnames[agent.surname]++
+			}
+			reportSurnameFrequencies(s.out, surnames)
+		}
+	}
+
+	founders, survivors := len(series.founderSurnames), 0
+	survived := make(map[int32]struct{})
+	for _, agent := range s.agents[s.genBdrys[lastGen-1]:] {
+		if agent.sex == sex {
+			survived[agent.surname] = struct{}{}
+		}
+	}
+	for _, surname := range series.founderSurnames {
+		if _, found := survived[surname]; found {
+			survivors++
+		}
+	}
+	total := 0
+	for _, n := range series.offspring {
+		total += n
+	}
+	if total == 0 || founders == 0 {
+		return
+	}
+	probs := make([]float64, len(series.offspring))
+	mean := 0.0
+	for k, n := range series.offspring {
+		probs[k] = float64(n) / float64(total)
+		mean += float64(k) * probs[k]
+	}
+	q := extinctionProbabilities(probs, lastGen)
+	ultimate := extinctionProbabilities(probs, extinctionGenerations)[extinctionGenerations]
+	fmt.Fprintf(s.out, "Mean same sex children per surname bearer: %.4f\n", mean)
+	fmt.Fprintf(s.out, "Proportion of founder surnames surviving: observed %.4f, branching process %.4f\n",
+		float64(survivors)/float64(founders), 1.0-q[lastGen])
+	fmt.Fprintf(s.out, "Branching process probability of eventual extinction: %.4f\n", ultimate)
+}
+
+// Reports how many surnames have each number of bearers
+func reportSurnameFrequencies(w io.Writer, surnames map[int32]int) {
+	frequencies := make(map[int]int)
+	largest := 0
+	for _, n := range surnames {
+		frequencies[n]++
+		largest = max(largest, n)
+	}
+	fmt.Fprintln(w, "Surname frequency distribution in last generation (bearers, surnames):")
+	for n := 1; n <= largest; n++ {
+		if frequencies[n] > 0 {
+			fmt.Fprintf(w, "%d %d\n", n, frequencies[n])
+		}
+	}
+}
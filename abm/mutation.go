@@ -0,0 +1,168 @@
+package abm
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Mutation models
+const (
+	// Every mutation creates a new allele
+	INFINITE_ALLELES = "infinite-alleles"
+	// Every mutation occurs at a new site so alleles accumulate sites
+	INFINITE_SITES = "infinite-sites"
+	// Mutations add or remove one repeat so alleles can recur
+	STEPWISE = "stepwise"
+)
+
+// A mutation of a gene. Parent is the id of the previous mutation on the
+// same gene, 0 if there was none, so following parents gives every
+// mutation the gene carries. Agent is the id of the child the mutation
+// arose in, or -1 if that agent has since been removed by Simplify. State
+// is the number of repeats relative to the founder gene under the
+// stepwise model.
+type Mutation struct {
+	Id         int32
+	Parent     int32
+	Locus      int32
+	Generation int
+	Agent      int
+	State      int32
+}
+
+// An allele, which is what analyses compare genes by. Under the stepwise
+// model genes with the same number of repeats are the same allele even if
+// their mutations arose independently. Otherwise every mutation makes a
+// new allele.
+type allele struct {
+	gene  Gene
+	state int32
+}
+
+// Returns the mutation with the given id
+func (s *Simulation) mutation(id int32) *Mutation {
+	return &s.mutations[id-1]
+}
+
+// Returns the allele a gene carries
+func (s *Simulation) allele(g Gene) allele {
+	if s.params.MutationModel != STEPWISE || g.Lineage == 0 {
+		return allele{gene: g}
+	}
+	state := s.mutation(g.Lineage).State
+	g.Lineage = 0
+	return allele{gene: g, state: state}
+}
+
+// Returns the mutation rate of a locus
+func (s *Simulation) mutationRate(locus int) float64 {
+	if locus < len(s.params.LocusMutationRates) {
+		return s.params.LocusMutationRates[locus]
+	}
+	return s.params.MutationRate
+}
+
+// Passes a gene from parent to child, mutating it at the mutation rate of
+// its locus
+func (s *Simulation) transmit(g Gene, child *Agent) Gene {
+	rate := s.mutationRate(int(g.Locus))
+	if rate > 0.0 && rand.Float64() < rate {
+		return s.mutate(g, child)
+	}
+	return g
+}
+
+// Returns a copy of the gene with a new mutation arising in child
+func (s *Simulation) mutate(g Gene, child *Agent) Gene {
+	m := Mutation{
+		Id:         int32(len(s.mutations) + 1),
+		Parent:     g.Lineage,
+		Locus:      g.Locus,
+		Generation: child.generation,
+		Agent:      child.id,
+	}
+	if s.params.MutationModel == STEPWISE {
+		if g.Lineage != 0 {
+			m.State = s.mutation(g.Lineage).State
+		}
+		if rand.Float64() < 0.5 {
+			m.State--
+		} else {
+			m.State++
+		}
+	}
+	s.mutations = append(s.mutations, m)
+	g.Lineage = m.Id
+	return g
+}
+
+// Reports the mutations carried by the last generation: how many there
+// are, how many are fixed or still segregating and how old the
+// segregating mutations are. Under the infinite sites model a gene carries
+// every mutation in its history, so each segregating mutation is a
+// segregating site. Otherwise a gene only carries its latest mutation,
+// which defines its allele. Under the stepwise model the mean variance in
+// repeat number across loci is also reported.
+func (s *Simulation) reportMutations() {
+	model := s.params.MutationModel
+	fmt.Fprintf(s.out, "Mutation model %s: %d mutations\n", model, len(s.mutations))
+	lastGen := s.agents[len(s.agents)-1].generation
+	agents := s.agents[s.genBdrys[lastGen-1]:]
+	copies := len(agents) * s.ploidy()
+	carried := make(map[int32]int)
+	states := make([][]float64, s.params.NumGenes)
+	for _, agent := range agents {
+		for _, gene := range agent.genes {
+			if model == INFINITE_SITES {
+				for id := gene.Lineage; id != 0; id = s.mutation(id).Parent {
+					carried[id]++
+				}
+			} else if gene.Lineage != 0 {
+				carried[gene.Lineage]++
+			}
+			if model == STEPWISE {
+				states[gene.Locus] = append(states[gene.Locus], float64(s.allele(gene).state))
+			}
+		}
+	}
+	fixed, segregating, totalAge := 0, 0, 0
+	for id, n := range carried {
+		if n == copies {
+			fixed++
+		} else {
+			segregating++
+			totalAge += lastGen - s.mutation(id).Generation
+		}
+	}
+	fmt.Fprintf(s.out, "Mutations in last generation: %d carried, %d fixed, %d segregating\n",
+		len(carried), fixed, segregating)
+	if segregating > 0 {
+		fmt.Fprintf(s.out, "Mean age of segregating mutations: %.3f generations\n",
+			float64(totalAge)/float64(segregating))
+	}
+	if model == STEPWISE && s.params.NumGenes > 0 {
+		total := 0.0
+		for _, values := range states {
+			total += variance(values)
+		}
+		fmt.Fprintf(s.out, "Mean variance in repeat number across loci: %.4f\n",
+			total/float64(s.params.NumGenes))
+	}
+}
+
+// Returns the population variance of values
+func variance(values []float64) float64 {
+	if len(values) == 0 {
+		return 0.0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	total := 0.0
+	for _, v := range values {
+		total += (v - mean) * (v - mean)
+	}
+	return total / float64(len(values))
+}
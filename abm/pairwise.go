@@ -0,0 +1,148 @@
+package abm
+
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+// Returns the number of workers to use for pairwise analyses
+func (s *Simulation) numWorkers() int {
+	if s.params.Workers > 0 {
+		return s.params.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// Returns the number of workers to split n units of work between
+func (s *Simulation) pairWorkers(n int) int {
+	return max(1, min(s.numWorkers(), n))
+}
+
+// Runs fn on each worker and waits for them all to finish
+func runWorkers(workers int, fn func(w int)) {
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(w)
+		}()
+	}
+	wg.Wait()
+}
+
+// Creates an empty distribution for each worker
+func newDistributions(workers int) []distribution {
+	partial := make([]distribution, workers)
+	for w := range partial {
+		partial[w] = newDistribution()
+	}
+	return partial
+}
+
+// Merges the distributions of each worker in worker order, so the result
+// doesn't depend on goroutine scheduling
+func mergeDistributions(partial []distribution) distribution {
+	result := newDistribution()
+	for _, stats := range partial {
+		result.merge(stats)
+	}
+	return result
+}
+
+// Calls visit for every pair of agents with ids in [start, end), split
+// across workers. Rows of pairs are dealt out to workers in turn so that
+// each gets a similar share of the short and long rows. visit is told
+// which worker is calling it so it can keep separate results per worker.
+func (s *Simulation) visitAllPairs(start, end, workers int, visit func(w int, a, b *Agent)) {
+	runWorkers(workers, func(w int) {
+		for i := start + w; i < end; i += workers {
+			a := &s.agents[i]
+			for j := i + 1; j < end; j++ {
+				visit(w, a, &s.agents[j])
+			}
+		}
+	})
+}
+
+// Draws n random pairs of distinct agents with ids in [start, end). Pairs
+// are drawn with replacement.
+func samplePairs(start, end, n int) [][2]int {
+	pairs := make([][2]int, n)
+	size := end - start
+	for k := range pairs {
+		i := rand.Intn(size)
+		j := rand.Intn(size - 1)
+		if j >= i {
+			j++
+		}
+		pairs[k] = [2]int{start + i, start + j}
+	}
+	return pairs
+}
+
+// Calls visit for n randomly sampled pairs of agents with ids in
+// [start, end), split across workers. The pairs are drawn before the work
+// is split so the sample doesn't depend on the number of workers.
+func (s *Simulation) visitSampledPairs(start, end, n, workers int, visit func(w int, a, b *Agent)) {
+	pairs := samplePairs(start, end, n)
+	runWorkers(workers, func(w int) {
+		for k := w; k < len(pairs); k += workers {
+			visit(w, &s.agents[pairs[k][0]], &s.agents[pairs[k][1]])
+		}
+	})
+}
+
+// Checks if pairwise analyses of agents with ids in [start, end) should
+// use a sample of pairs, which they do if the PairSample parameter is set
+// and is smaller than the number of pairs
+func (s *Simulation) samplingPairs(start, end int) bool {
+	n := end - start
+	return s.params.PairSample > 0 && s.params.PairSample < n*(n-1)/2
+}
+
+// Calls visit for the pairs of agents with ids in [start, end) used by
+// pairwise analyses, which are all pairs or a random sample of them.
+// Returns true if pairs were sampled.
+func (s *Simulation) visitPairs(start, end, workers int, visit func(w int, a, b *Agent)) bool {
+	if s.samplingPairs(start, end) {
+		s.visitSampledPairs(start, end, s.params.PairSample, workers, visit)
+		return true
+	}
+	s.visitAllPairs(start, end, workers, visit)
+	return false
+}
+
+// Calculates fn for every pair of agents with ids in [start, end) and
+// returns the summary statistics
+func (s *Simulation) pairwise(start, end int, fn func(a, b *Agent) int) distribution {
+	workers := s.pairWorkers(end - start)
+	partial := newDistributions(workers)
+	s.visitAllPairs(start, end, workers, func(w int, a, b *Agent) {
+		partial[w].add(fn(a, b))
+	})
+	return mergeDistributions(partial)
+}
+
+// Estimates the statistics of fn over pairs of agents with ids in
+// [start, end) from n randomly sampled pairs
+func (s *Simulation) pairwiseSample(start, end, n int, fn func(a, b *Agent) int) distribution {
+	workers := s.pairWorkers(n)
+	partial := newDistributions(workers)
+	s.visitSampledPairs(start, end, n, workers, func(w int, a, b *Agent) {
+		partial[w].add(fn(a, b))
+	})
+	stats := mergeDistributions(partial)
+	stats.sampled = true
+	return stats
+}
+
+// Calculates the statistics of fn over the pairs of agents with ids in
+// [start, end) used by pairwise analyses
+func (s *Simulation) pairStatistics(start, end int, fn func(a, b *Agent) int) distribution {
+	if s.samplingPairs(start, end) {
+		return s.pairwiseSample(start, end, s.params.PairSample, fn)
+	}
+	return s.pairwise(start, end, fn)
+}
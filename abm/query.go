@@ -0,0 +1,220 @@
+package abm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The pedigree links of one agent. Mother and father are Unknown if they
+// aren't known.
+type AgentInfo struct {
+	Id         int
+	Generation int
+	Sex        Sex
+	Mother     int
+	Father     int
+	Children   []int
+}
+
+// Returns the pedigree links of an agent
+func (s *Simulation) Agent(id int) (AgentInfo, error) {
+	if id < 0 || id >= len(s.agents) {
+		return AgentInfo{}, fmt.Errorf("no agent with id %d", id)
+	}
+	agent := &s.agents[id]
+	return AgentInfo{
+		Id:         id,
+		Generation: agent.generation,
+		Sex:        agent.sex,
+		Mother:     agent.mother,
+		Father:     agent.father,
+		Children:   slices.Clone(agent.children),
+	}, nil
+}
+
+// Returns the sorted ids of an agent's ancestors up to depth generations
+// back, or all its ancestors if depth is 0 or less
+func (s *Simulation) Ancestors(id, depth int) ([]int, error) {
+	ids, err := s.reachable(id, depth, parentsOf)
+	if err != nil {
+		return nil, err
+	}
+	ids = ids[1:]
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Returns the sorted ids of an agent's descendants up to depth generations
+// forward, or all its descendants if depth is 0 or less
+func (s *Simulation) Descendants(id, depth int) ([]int, error) {
+	ids, err := s.reachable(id, depth, childrenOf)
+	if err != nil {
+		return nil, err
+	}
+	ids = ids[1:]
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Returns the number of generations back to each ancestor of an agent
+// along the shortest line of descent, including the agent itself at 0
+func (s *Simulation) generationsBack(id int) map[int]int {
+	back := map[int]int{id: 0}
+	queue := []int{id}
+	for i := 0; i < len(queue); i++ {
+		for _, parent := range parentsOf(&s.agents[queue[i]]) {
+			if _, found := back[parent]; !found {
+				back[parent] = back[queue[i]] + 1
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return back
+}
+
+// How two agents are related. Common holds their most recent common
+// ancestors, which are GenerationsA generations back from the first agent
+// and GenerationsB back from the second. Either agent may itself be the
+// common ancestor of the other. Name describes the first agent's
+// relationship to the second, e.g. "2nd cousin once removed".
+type Relationship struct {
+	Common       []int
+	GenerationsA int
+	GenerationsB int
+	Name         string
+}
+
+// Returns the most recent common ancestors of two agents: the common
+// ancestors with the fewest generations between them and the two agents.
+// An agent counts as its own ancestor, so if one agent is an ancestor of
+// the other it is returned. The result is empty if the agents aren't
+// related in the pedigree.
+func (s *Simulation) MRCA(a, b int) ([]int, error) {
+	r, err := s.Relationship(a, b)
+	return r.Common, err
+}
+
+// Returns how two agents are related through their most recent common
+// ancestors. Common ancestors the same number of generations away in total
+// can be different numbers of generations back from each agent, so ties
+// are broken in favour of the most even split and then of the fewest
+// generations back from the first agent. Only common ancestors with the
+// chosen split are returned.
+func (s *Simulation) Relationship(a, b int) (Relationship, error) {
+	for _, id := range [...]int{a, b} {
+		if id < 0 || id >= len(s.agents) {
+			return Relationship{}, fmt.Errorf("no agent with id %d", id)
+		}
+	}
+	backA, backB := s.generationsBack(a), s.generationsBack(b)
+	ids := make([]int, 0, len(backA))
+	for id := range backA {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	var r Relationship
+	var best [3]int
+	for _, id := range ids {
+		upA := backA[id]
+		upB, found := backB[id]
+		if !found {
+			continue
+		}
+		key := [3]int{upA + upB, max(upA, upB), upA}
+		if len(r.Common) == 0 || slices.Compare(key[:], best[:]) < 0 {
+			best = key
+			r.Common = r.Common[:0]
+			r.GenerationsA, r.GenerationsB = upA, upB
+		}
+		if key == best {
+			r.Common = append(r.Common, id)
+		}
+	}
+	parentsKnown := true
+	if len(r.Common) == 1 {
+		parentsKnown = s.lineParentsKnown(r.Common[0], backA, r.GenerationsA) &&
+			s.lineParentsKnown(r.Common[0], backB, r.GenerationsB)
+	}
+	r.Name = relationshipTerm(s.agents[a].sex, r.GenerationsA, r.GenerationsB, len(r.Common), parentsKnown)
+	return r, nil
+}
+
+// Returns whether both parents are known of every child of a common
+// ancestor on a shortest line of descent up generations long. Relatives
+// sharing only one common ancestor are only known to be half relatives if
+// the other parents on both lines are known, and so differ.
+func (s *Simulation) lineParentsKnown(ancestor int, back map[int]int, up int) bool {
+	for _, child := range s.agents[ancestor].children {
+		if generations, found := back[child]; found && generations == up-1 {
+			if len(parentsOf(&s.agents[child])) < 2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the male, female or, for an unknown sex, neutral form of a
+// relationship term
+func gendered(sex Sex, male, female, neutral string) string {
+	switch sex {
+	case MALE:
+		return male
+	case FEMALE:
+		return female
+	}
+	return neutral
+}
+
+// Returns "grand" with greats for relatives more than one generation
+// beyond the nearest, e.g. "great-grand" for three generations
+func grand(generations int) string {
+	if generations < 2 {
+		return ""
+	}
+	return strings.Repeat("great-", generations-2) + "grand"
+}
+
+// Names the relationship of an agent of the given sex to another agent
+// when their nearest common ancestors are upA and upB generations back
+// and there are the given number of them. Collateral relatives sharing a
+// single common ancestor are half relatives if the other parents on both
+// lines are known, and may be half or full relatives otherwise.
+func relationshipTerm(sex Sex, upA, upB, common int, parentsKnown bool) string {
+	if common == 0 {
+		return "unrelated"
+	}
+	half := ""
+	if common == 1 && upA > 0 && upB > 0 {
+		half = "half "
+		if !parentsKnown {
+			half = "half or full "
+		}
+	}
+	switch {
+	case upA == 0 && upB == 0:
+		return "self"
+	case upA == 0:
+		return grand(upB) + gendered(sex, "father", "mother", "parent")
+	case upB == 0:
+		return grand(upA) + gendered(sex, "son", "daughter", "child")
+	case upA == 1 && upB == 1:
+		return half + gendered(sex, "brother", "sister", "sibling")
+	case upA == 1:
+		return half + strings.Repeat("great-", upB-2) + gendered(sex, "uncle", "aunt", "uncle or aunt")
+	case upB == 1:
+		return half + strings.Repeat("great-", upA-2) + gendered(sex, "nephew", "niece", "nephew or niece")
+	}
+	name := half + ordinal(min(upA, upB)-1) + " cousin"
+	switch removed := max(upA, upB) - min(upA, upB); removed {
+	case 0:
+		return name
+	case 1:
+		return name + " once removed"
+	case 2:
+		return name + " twice removed"
+	default:
+		return fmt.Sprintf("%s %d times removed", name, removed)
+	}
+}
@@ -0,0 +1,155 @@
+package abm
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Dominance of the focal variant at selected loci in diploid agents
+const (
+	// A heterozygote gets half the effect of a homozygote
+	ADDITIVE = "additive"
+	// A heterozygote gets the full effect
+	DOMINANT = "dominant"
+	// A heterozygote gets no effect
+	RECESSIVE = "recessive"
+)
+
+// Checks if genes affect reproductive success
+func (s *Simulation) selectionActive() bool {
+	return s.params.SelectedLoci > 0 && s.params.SelectionCoefficient != 0.0
+}
+
+// Returns the proportion of the selection coefficient a heterozygote gets
+func (s *Simulation) dominance() float64 {
+	switch s.params.Dominance {
+	case DOMINANT:
+		return 1.0
+	case RECESSIVE:
+		return 0.0
+	default:
+		return 0.5
+	}
+}
+
+// Returns the relative fitness of an agent. Each selected locus carrying
+// the focal variant multiplies fitness by 1 + s, or by 1 + hs for a
+// diploid heterozygote where h depends on the dominance.
+func (s *Simulation) fitness(agent *Agent) float64 {
+	ploidy := s.ploidy()
+	w := 1.0
+	for locus := range min(s.params.SelectedLoci, s.params.NumGenes) {
+		variants := 0
+		for _, gene := range agent.genes[locus*ploidy : (locus+1)*ploidy] {
+			if gene.Variant {
+				variants++
+			}
+		}
+		if variants == ploidy {
+			w *= 1.0 + s.params.SelectionCoefficient
+		} else if variants > 0 {
+			w *= 1.0 + s.dominance()*s.params.SelectionCoefficient
+		}
+	}
+	return max(w, 0.0)
+}
+
+// Picks indices at random with probability proportional to their weights
+type sampler struct {
+	cumulative []float64
+}
+
+// Creates a sampler over the given weights
+func newSampler(weights []float64) sampler {
+	cumulative := make([]float64, len(weights))
+	total := 0.0
+	for i, w := range weights {
+		total += w
+		cumulative[i] = total
+	}
+	return sampler{cumulative}
+}
+
+// Returns a random index, or a uniformly chosen one if every weight is zero
+func (sm sampler) pick() int {
+	n := len(sm.cumulative)
+	total := sm.cumulative[n-1]
+	if total <= 0.0 {
+		return rand.Intn(n)
+	}
+	return min(sort.SearchFloat64s(sm.cumulative, rand.Float64()*total), n-1)
+}
+
+// Creates a sampler that picks agents in proportion to their fitness
+func (s *Simulation) fitnessSampler(ids []int) sampler {
+	weights := make([]float64, len(ids))
+	for i, id := range ids {
+		weights[i] = s.fitness(&s.agents[id])
+	}
+	return newSampler(weights)
+}
+
+// Creates a sampler that picks mating pairs in proportion to the product
+// of the partners' fitness
+func (s *Simulation) pairSampler() sampler {
+	weights := make([]float64, len(s.matingPairs))
+	for i, pair := range s.matingPairs {
+		weights[i] = s.fitness(&s.agents[pair.male]) * s.fitness(&s.agents[pair.female])
+	}
+	return newSampler(weights)
+}
+
+// Reports, for each generation, the mean fitness and the frequency of
+// the focal variant at the selected loci and at the neutral loci, whose
+// variant only changes in frequency by drift
+func (s *Simulation) reportSelection() {
+	if s.params.NumGenes == 0 {
+		fmt.Fprintln(s.out, "No genes in simulation")
+		return
+	}
+	fmt.Fprintln(s.out, "Generation, mean fitness, variant frequency at selected loci, at neutral loci, at each selected locus:")
+	for _, row := range s.generationSeries().selection {
+		fmt.Fprintln(s.out, row)
+	}
+}
+
+// Returns the selection report row of the agents of a generation
+func (s *Simulation) selectionRow(gen int, agents []Agent) string {
+	selected := min(s.params.SelectedLoci, s.params.NumGenes)
+	counts := make([]int, s.params.NumGenes)
+	totalFitness := 0.0
+	for i := range agents {
+		totalFitness += s.fitness(&agents[i])
+		for _, gene := range agents[i].genes {
+			if gene.Variant {
+				counts[gene.Locus]++
+			}
+		}
+	}
+	copies := float64(len(agents) * s.ploidy())
+	frequencies := make([]string, selected)
+	selectedTotal, neutralTotal := 0, 0
+	for locus, count := range counts {
+		if locus < selected {
+			frequencies[locus] = fmt.Sprintf("%.4f", float64(count)/copies)
+			selectedTotal += count
+		} else {
+			neutralTotal += count
+		}
+	}
+	return fmt.Sprintf("%d %.4f %s %s %s", gen, totalFitness/float64(len(agents)),
+		meanFrequency(selectedTotal, selected, copies),
+		meanFrequency(neutralTotal, s.params.NumGenes-selected, copies),
+		strings.Join(frequencies, " "))
+}
+
+// Formats the mean frequency of a variant over a number of loci, or - if
+// there are no loci
+func meanFrequency(count, loci int, copies float64) string {
+	if loci == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.4f", float64(count)/(float64(loci)*copies))
+}
@@ -0,0 +1,120 @@
+package abm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Per generation statistics of the time series reports. Simplify removes
+// agents that have no descendants in the last generation, which would
+// bias these statistics for earlier generations, so each generation is
+// recorded before any of its agents are removed. Only generations before
+// the last are recorded, because the last generation's agents may still
+// have children.
+type generationSeries struct {
+	// Number of generations recorded
+	recorded  int
+	diversity []DiversityStats
+	// Allele counts of the last generation with agents, for lost alleles
+	alleles map[allele]int
+	// Gene report of each generation with agents
+	genes     []string
+	lineages  []string
+	selection []string
+	surnames  []string
+	// Number of surname bearers by their number of same sex children
+	offspring []int
+	// Surnames of the founders of the surname sex
+	founderSurnames []int32
+}
+
+// Records the statistics of every generation before the given one that
+// has not been recorded yet
+func (s *Simulation) recordGenerations(before int) {
+	for s.series.recorded < min(before, len(s.genBdrys)) {
+		s.recordGeneration(&s.series, s.series.recorded, true)
+		s.series.recorded++
+	}
+}
+
+// Returns the statistics of every generation: the recorded ones and those
+// of the remaining generations calculated from the current agents
+func (s *Simulation) generationSeries() generationSeries {
+	if len(s.agents) == 0 {
+		return generationSeries{}
+	}
+	lastGen := s.agents[len(s.agents)-1].generation
+	s.recordGenerations(lastGen)
+	series := s.series
+	series.diversity = slices.Clip(series.diversity)
+	series.genes = slices.Clip(series.genes)
+	series.lineages = slices.Clip(series.lineages)
+	series.selection = slices.Clip(series.selection)
+	series.surnames = slices.Clip(series.surnames)
+	series.offspring = slices.Clone(series.offspring)
+	series.founderSurnames = slices.Clip(series.founderSurnames)
+	for gen := series.recorded; gen < len(s.genBdrys); gen++ {
+		s.recordGeneration(&series, gen, gen < lastGen)
+	}
+	return series
+}
+
+// Adds the statistics of a generation to a series. Children are only
+// counted for surname bearers once they all have been born.
+func (s *Simulation) recordGeneration(series *generationSeries, gen int, hadChildren bool) {
+	start := 0
+	if gen > 0 {
+		start = s.genBdrys[gen-1]
+	}
+	agents := s.agents[start:s.genBdrys[gen]]
+	series.lineages = append(series.lineages, lineageRow(gen, agents))
+	if s.params.Surnames != "" {
+		series.surnames = append(series.surnames, s.surnameRow(series, gen, agents, hadChildren))
+	}
+	if len(agents) == 0 {
+		return
+	}
+	var genes strings.Builder
+	s.analyzeGenes(&genes, agents)
+	series.genes = append(series.genes, genes.String())
+	if s.params.NumGenes == 0 {
+		return
+	}
+	stats, counts := s.diversityStats(gen, agents, series.alleles)
+	series.diversity = append(series.diversity, stats)
+	series.alleles = counts
+	series.selection = append(series.selection, s.selectionRow(gen, agents))
+}
+
+// Returns the surname report row of a generation, adding the children of
+// its surname bearers and its founders to the series
+func (s *Simulation) surnameRow(series *generationSeries, gen int, agents []Agent, hadChildren bool) string {
+	sex := s.surnameSex()
+	surnames := make(map[int32]int)
+	carriers := make(map[int32]struct{})
+	for _, agent := range agents {
+		surnames[agent.surname]++
+		if agent.sex != sex {
+			continue
+		}
+		carriers[agent.surname] = struct{}{}
+		if gen == 0 {
+			series.founderSurnames = append(series.founderSurnames, agent.surname)
+		}
+		if !hadChildren {
+			continue
+		}
+		k := 0
+		for _, child := range agent.children {
+			if s.agents[child].sex == sex {
+				k++
+			}
+		}
+		for len(series.offspring) <= k {
+			series.offspring = append(series.offspring, 0)
+		}
+		series.offspring[k]++
+	}
+	return fmt.Sprintf("%d %d %d", gen, len(surnames), len(carriers))
+}
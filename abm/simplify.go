@@ -0,0 +1,87 @@
+package abm
+
+// Removes every agent that is neither in the last generation nor an
+// ancestor of an agent in the last generation, and renumbers the remaining
+// agents so that ids are still indices into the agents slice. Parents,
+// children and any ancestor sets already calculated are renumbered to
+// match, so ancestry results are unchanged. Mutations that arose in a
+// removed agent record it as -1. Genes keep the id of the founder they
+// came from as it was when the simulation started. The per generation
+// statistics of the reports are recorded first, so they still describe
+// every agent. Returns the number of agents removed.
+func (s *Simulation) Simplify() int {
+	if len(s.agents) == 0 {
+		return 0
+	}
+	lastGen := s.agents[len(s.agents)-1].generation
+	if lastGen == 0 {
+		return 0
+	}
+	s.recordGenerations(lastGen)
+	// Parents always have lower ids than their children, so a single
+	// backwards pass marks every ancestor of the last generation.
+	keep := make([]bool, len(s.agents))
+	for i := s.genBdrys[lastGen-1]; i < len(s.agents); i++ {
+		keep[i] = true
+	}
+	for i := len(s.agents) - 1; i >= 0; i-- {
+		if keep[i] {
+			for _, parent := range parentsOf(&s.agents[i]) {
+				keep[parent] = true
+			}
+		}
+	}
+	newIds := make([]int, len(s.agents))
+	n := 0
+	for i := range s.agents {
+		if keep[i] {
+			newIds[i] = n
+			n++
+		} else {
+			newIds[i] = -1
+		}
+	}
+	removed := len(s.agents) - n
+	if removed == 0 {
+		return 0
+	}
+
+	agents := make([]Agent, 0, n)
+	for i := range s.agents {
+		if !keep[i] {
+			continue
+		}
+		agent := s.agents[i]
+		agent.id = newIds[i]
+		if agent.mother != Unknown {
+			agent.mother = newIds[agent.mother]
+		}
+		if agent.father != Unknown {
+			agent.father = newIds[agent.father]
+		}
+		children := agent.children[:0]
+		for _, child := range agent.children {
+			if keep[child] {
+				children = append(children, newIds[child])
+			}
+		}
+		agent.children = children
+		if agent.ancestors != nil {
+			var ancestors bitset
+			agent.ancestors.each(func(id int) {
+				ancestors.add(newIds[id])
+			})
+			agent.ancestors = ancestors
+		}
+		agents = append(agents, agent)
+	}
+	s.agents = agents
+	for i := range s.mutations {
+		if s.mutations[i].Agent >= 0 {
+			s.mutations[i].Agent = newIds[s.mutations[i].Agent]
+		}
+	}
+	s.SetGenBdrys()
+	s.setCurrGen(lastGen)
+	return removed
+}
@@ -0,0 +1,281 @@
+package abm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// z value for a 95% confidence interval
+const z95 = 1.959964
+
+// The distribution of an integer statistic, such as the number of
+// ancestors of agents or common ancestors of pairs. Besides running totals
+// it keeps the number of times each value occurs, which is small because
+// values are bounded by the number of agents, so quantiles are exact and
+// distributions calculated separately can be merged. The statistics are
+// usually non-negative, so negative values are counted separately.
+type distribution struct {
+	min   int
+	max   int
+	sum   int
+	sumSq float64
+	count int
+	// Number of times each value occurs
+	counts []int
+	// Number of times each negative value occurs, starting from -1
+	negative []int
+	// True if the statistics are estimated from a random sample of pairs
+	sampled bool
+}
+
+// Creates an empty distribution
+func newDistribution() distribution {
+	return distribution{
+		min: math.MaxInt,
+		max: math.MinInt,
+	}
+}
+
+// Adds a value to the distribution
+func (d *distribution) add(value int) {
+	if value < d.min {
+		d.min = value
+	}
+	if value > d.max {
+		d.max = value
+	}
+	d.sum += value
+	d.sumSq += float64(value) * float64(value)
+	d.count++
+	if value < 0 {
+		for len(d.negative) < -value {
+			d.negative = append(d.negative, 0)
+		}
+		d.negative[-value-1]++
+		return
+	}
+	for len(d.counts) <= value {
+		d.counts = append(d.counts, 0)
+	}
+	d.counts[value]++
+}
+
+// Returns the number of times a value occurs
+func (d distribution) occurrences(value int) int {
+	if value < 0 {
+		if -value <= len(d.negative) {
+			return d.negative[-value-1]
+		}
+		return 0
+	}
+	if value < len(d.counts) {
+		return d.counts[value]
+	}
+	return 0
+}
+
+// Combines a distribution calculated separately into d
+func (d *distribution) merge(other distribution) {
+	d.min = min(d.min, other.min)
+	d.max = max(d.max, other.max)
+	d.sum += other.sum
+	d.sumSq += other.sumSq
+	d.count += other.count
+	for len(d.counts) < len(other.counts) {
+		d.counts = append(d.counts, 0)
+	}
+	for value, n := range other.counts {
+		d.counts[value] += n
+	}
+	for len(d.negative) < len(other.negative) {
+		d.negative = append(d.negative, 0)
+	}
+	for i, n := range other.negative {
+		d.negative[i] += n
+	}
+}
+
+// Returns the mean of the values, or 0 if there are none
+func (d distribution) mean() float64 {
+	if d.count == 0 {
+		return 0.0
+	}
+	return float64(d.sum) / float64(d.count)
+}
+
+// Returns the sample standard deviation of the values
+func (d distribution) stdDev() float64 {
+	if d.count < 2 {
+		return 0.0
+	}
+	mean := d.mean()
+	variance := (d.sumSq - float64(d.count)*mean*mean) / float64(d.count-1)
+	return math.Sqrt(max(variance, 0.0))
+}
+
+// Returns the standard error of the mean
+func (d distribution) stdErr() float64 {
+	if d.count == 0 {
+		return 0.0
+	}
+	return d.stdDev() / math.Sqrt(float64(d.count))
+}
+
+// Returns the 95% confidence interval of the mean
+func (d distribution) confInt() (float64, float64) {
+	margin := z95 * d.stdErr()
+	return d.mean() - margin, d.mean() + margin
+}
+
+// Returns the k-th smallest value, counting from 0
+func (d distribution) nth(k int) int {
+	seen := 0
+	for value := d.min; value < d.max; value++ {
+		seen += d.occurrences(value)
+		if seen > k {
+			return value
+		}
+	}
+	return d.max
+}
+
+// Returns the q quantile of the values, interpolating between the two
+// nearest values as R's default and spreadsheets do
+func (d distribution) quantile(q float64) float64 {
+	if d.count == 0 {
+		return 0.0
+	}
+	h := q * float64(d.count-1)
+	k := int(math.Floor(h))
+	lo := float64(d.nth(k))
+	if k+1 >= d.count {
+		return lo
+	}
+	hi := float64(d.nth(k + 1))
+	return lo + (h-float64(k))*(hi-lo)
+}
+
+// Returns a histogram of the values with at most the given number of
+// bins. Bins are equal width ranges of whole numbers from min to max.
+func (d distribution) histogram(bins int) []Bin {
+	if d.count == 0 || bins < 1 {
+		return nil
+	}
+	width := (d.max - d.min + bins) / bins
+	var result []Bin
+	for low := d.min; low <= d.max; low += width {
+		bin := Bin{Low: low, High: min(low+width-1, d.max)}
+		for value := bin.Low; value <= bin.High; value++ {
+			bin.Count += d.occurrences(value)
+		}
+		result = append(result, bin)
+	}
+	return result
+}
+
+// Returns a line describing the precision of sampled statistics, or an
+// empty string if every pair was compared.
+func (d distribution) sampleReport() string {
+	if !d.sampled {
+		return ""
+	}
+	lo, hi := d.confInt()
+	return fmt.Sprintf("Estimated from %d sampled pairs: mean %.3f, standard error %.3f, 95%% CI [%.3f, %.3f]\n",
+		d.count, d.mean(), d.stdErr(), lo, hi)
+}
+
+// Returns the heading of the min, max and mean line. The min and max of a
+// sample are only those of the sampled pairs.
+func (d distribution) summaryLabel() string {
+	if d.sampled {
+		return "Sample min, max, mean"
+	}
+	return "Min, max, mean"
+}
+
+// A histogram bin counting the values from Low to High inclusive
+type Bin struct {
+	Low   int `json:"low"`
+	High  int `json:"high"`
+	Count int `json:"count"`
+}
+
+// A quantile of a statistic, e.g. P 0.5 is the median
+type Quantile struct {
+	P     float64 `json:"p"`
+	Value float64 `json:"value"`
+}
+
+// Summary of the distribution of a statistic. If the statistic was
+// estimated from sampled pairs the standard error and 95% confidence
+// interval of the mean are also given.
+type Statistic struct {
+	Count     int        `json:"count"`
+	Min       int        `json:"min"`
+	Max       int        `json:"max"`
+	Mean      float64    `json:"mean"`
+	StdDev    float64    `json:"stdDev"`
+	Median    float64    `json:"median"`
+	Quantiles []Quantile `json:"quantiles"`
+	Histogram []Bin      `json:"histogram"`
+	Sampled   bool       `json:"sampled"`
+	StdErr    float64    `json:"stdErr,omitempty"`
+	CILow     float64    `json:"ciLow,omitempty"`
+	CIHigh    float64    `json:"ciHigh,omitempty"`
+}
+
+// Summarises a distribution using the quantiles and number of histogram
+// bins in the simulation parameters
+func (s *Simulation) statistic(d distribution) *Statistic {
+	st := &Statistic{
+		Count:     d.count,
+		Min:       d.min,
+		Max:       d.max,
+		Mean:      d.mean(),
+		StdDev:    d.stdDev(),
+		Median:    d.quantile(0.5),
+		Histogram: d.histogram(s.params.HistogramBins),
+		Sampled:   d.sampled,
+	}
+	for _, q := range s.params.Quantiles {
+		st.Quantiles = append(st.Quantiles, Quantile{P: q, Value: d.quantile(q)})
+	}
+	if d.sampled {
+		st.StdErr = d.stdErr()
+		st.CILow, st.CIHigh = d.confInt()
+	}
+	return st
+}
+
+// Writes the standard deviation, median, quantiles and histogram of a statistic
+func (st *Statistic) print(w io.Writer) {
+	fmt.Fprintf(w, "Standard deviation, median: %.3f %v\n", st.StdDev, st.Median)
+	if len(st.Quantiles) > 0 {
+		quantiles := make([]string, len(st.Quantiles))
+		for i, q := range st.Quantiles {
+			quantiles[i] = fmt.Sprintf("%v: %v", q.P, q.Value)
+		}
+		fmt.Fprintf(w, "Quantiles: %s\n", strings.Join(quantiles, ", "))
+	}
+	if len(st.Histogram) > 0 {
+		fmt.Fprintln(w, "Histogram:")
+		for _, bin := range st.Histogram {
+			fmt.Fprintf(w, "%d-%d %d\n", bin.Low, bin.High, bin.Count)
+		}
+	}
+}
+
+// Structured results of the analyses of a simulation. Statistics are nil
+// if their analysis wasn't run.
+type Results struct {
+	NumAncestors    *Statistic `json:"numAncestors,omitempty"`
+	CommonAncestors *Statistic `json:"commonAncestors,omitempty"`
+	GenerationDiff  *Statistic `json:"generationDiff,omitempty"`
+}
+
+// Returns the structured results of the last call to Analysis
+func (s *Simulation) Results() Results {
+	return s.results
+}
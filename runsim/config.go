@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A flag holding a comma separated list of floats
+type floatList struct {
+	values *[]float64
+}
+
+func (f floatList) String() string {
+	if f.values == nil {
+		return ""
+	}
+	fields := make([]string, len(*f.values))
+	for i, v := range *f.values {
+		fields[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(fields, ",")
+}
+
+func (f floatList) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		*f.values = nil
+		return nil
+	}
+	values, err := parseFloats(value)
+	*f.values = values
+	return err
+}
+
+func (f floatList) Get() any {
+	return *f.values
+}
+
+// A flag holding a comma separated list of integers
+type intList struct {
+	values *[]int
+}
+
+func (l intList) String() string {
+	if l.values == nil {
+		return ""
+	}
+	fields := make([]string, len(*l.values))
+	for i, v := range *l.values {
+		fields[i] = strconv.Itoa(v)
+	}
+	return strings.Join(fields, ",")
+}
+
+func (l intList) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		*l.values = nil
+		return nil
+	}
+	values, err := parseInts(value)
+	*l.values = values
+	return err
+}
+
+func (l intList) Get() any {
+	return *l.values
+}
+
+// Reads a configuration file of settings named after the command line
+// flags. Files with a .json extension hold a JSON object. Other files are
+// read as flat YAML with one "name: value" setting per line, # comments,
+// and lists written as [a, b, c].
+func readConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		return readJSONConfig(f)
+	}
+	return readYAMLConfig(f)
+}
+
+// Reads the settings in a JSON object, converting each value to the text
+// it would have on the command line. Null values are left at their
+// defaults.
+func readJSONConfig(r io.Reader) (map[string]string, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var values map[string]any
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	settings := make(map[string]string, len(values))
+	for name, value := range values {
+		if value == nil {
+			continue
+		}
+		text, err := configText(value)
+		if err != nil {
+			return nil, fmt.Errorf("setting %s: %w", name, err)
+		}
+		settings[name] = text
+	}
+	return settings, nil
+}
+
+// Converts a JSON value to command line text
+func configText(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		fields := make([]string, len(v))
+		for i, element := range v {
+			text, err := configText(element)
+			if err != nil {
+				return "", err
+			}
+			fields[i] = text
+		}
+		return strings.Join(fields, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// Reads the settings in a flat YAML file
+func readYAMLConfig(r io.Reader) (map[string]string, error) {
+	settings := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" || line == "---" {
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected name: value", lineNum)
+		}
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			fields := strings.Split(value[1:len(value)-1], ",")
+			for i := range fields {
+				fields[i] = unquote(strings.TrimSpace(fields[i]))
+			}
+			value = strings.Join(fields, ",")
+		}
+		settings[strings.TrimSpace(name)] = unquote(value)
+	}
+	return settings, scanner.Err()
+}
+
+// Removes a YAML comment from a line. A # starts a comment at the start of
+// the line or after whitespace, but not inside a quoted value. Values are
+// quoted if they start with a quote after a colon, bracket or comma.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			before := strings.TrimRight(line[:i], " \t")
+			if strings.HasSuffix(before, ":") || strings.HasSuffix(before, "[") ||
+				strings.HasSuffix(before, ",") {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Removes matching single or double quotes around a value
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Sets the flags named in a configuration file, except those given on the
+// command line, which override the file
+func applyConfig(flags *flag.FlagSet, path string) error {
+	settings, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	onCommandLine := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if name == "config" || flags.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %s", name)
+		}
+		if onCommandLine[name] {
+			continue
+		}
+		if err := flags.Set(name, settings[name]); err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Writes the value of every flag as a JSON object that can be used as a
+// configuration file to repeat the run
+func printConfig(w io.Writer, flags *flag.FlagSet) error {
+	values := make(map[string]any)
+	flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if getter, ok := f.Value.(flag.Getter); ok {
+			values[f.Name] = getter.Get()
+		} else {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	text, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Configuration: %s\n", text)
+	return err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nathangeffen/abm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestYAMLConfig(t *testing.T) {
+	text := `# Settings for a small run
+---
+agents: 50 # founders
+gedcom: "out#1.ged"
+dot: 'tree #2.dot'  # quoted with a space before the #
+diversity: div#3.csv
+analysis: NC#D
+quantiles: [0.1, "0.5", 0.9] # list
+import: it's #4.csv
+`
+	settings, err := readYAMLConfig(strings.NewReader(text))
+	require.NoError(t, err, "Read YAML configuration")
+	assert.Equal(t, map[string]string{
+		"agents":    "50",
+		"gedcom":    "out#1.ged",
+		"dot":       "tree #2.dot",
+		"diversity": "div#3.csv",
+		"analysis":  "NC#D",
+		"quantiles": "0.1,0.5,0.9",
+		"import":    "it's",
+	}, settings, "Settings with comments removed")
+
+	_, err = readYAMLConfig(strings.NewReader("agents 50\n"))
+	assert.EqualError(t, err, "line 1: expected name: value", "Setting without a colon")
+}
+
+func TestConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	defaults, defaultOptions, err := processFlags(flag.NewFlagSet("runsim", flag.ContinueOnError), nil)
+	require.NoError(t, err, "Default flags")
+	tests := []struct {
+		name string
+		file string
+		text string
+		args []string
+		// Changes from the default parameters and options
+		want func(p *abm.Parameters, o *options)
+		err  string
+	}{
+		{"JSON", "run.json",
+			`{"agents": 50, "track": true, "quantiles": [0.1, 0.9], "surnames": "mother", "gedcomids": [1, 2], "dot": null}`,
+			nil, func(p *abm.Parameters, o *options) {
+				p.NumAgents = 50
+				p.TrackAncestry = true
+				p.Quantiles = []float64{0.1, 0.9}
+				p.Surnames = "mother"
+				o.gedcomIds = []int{1, 2}
+			}, ""},
+		{"YAML", "run.yaml", "agents: 50\nquantiles: [0.1, 0.9]\ndot: tree.dot\n",
+			nil, func(p *abm.Parameters, o *options) {
+				p.NumAgents = 50
+				p.Quantiles = []float64{0.1, 0.9}
+				o.dot = "tree.dot"
+			}, ""},
+		{"Flags override JSON", "override.json", `{"agents": 50, "generations": 6}`,
+			[]string{"-agents", "70"}, func(p *abm.Parameters, o *options) {
+				p.NumAgents = 70
+				p.Generations = 6
+			}, ""},
+		{"Flags override YAML", "override.yaml", "quantiles: [0.1]\nanalysis: N\n",
+			[]string{"-quantiles", "0.3,0.7"}, func(p *abm.Parameters, o *options) {
+				p.Quantiles = []float64{0.3, 0.7}
+				p.Analysis = "N"
+			}, ""},
+		{"Unknown setting", "unknown.json", `{"agentz": 50}`,
+			nil, nil, "reading configuration file: unknown setting agentz"},
+		{"Nested configuration", "nested.yaml", "config: other.yaml\n",
+			nil, nil, "reading configuration file: unknown setting config"},
+		{"Invalid value", "invalid.json", `{"agents": "many"}`,
+			nil, nil, `reading configuration file: setting agents: parse error`},
+		{"Unsupported value", "object.json", `{"agents": {"n": 50}}`,
+			nil, nil, "reading configuration file: setting agents: unsupported value map[n:50]"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.file)
+		require.NoError(t, os.WriteFile(path, []byte(test.text), 0644), test.name)
+		flags := flag.NewFlagSet("runsim", flag.ContinueOnError)
+		p, o, err := processFlags(flags, append(test.args, "-config", path))
+		if test.err != "" {
+			assert.ErrorContains(t, err, test.err, test.name)
+			continue
+		}
+		require.NoError(t, err, test.name)
+		want, wantOptions := defaults, defaultOptions
+		test.want(&want, &wantOptions)
+		assert.Equal(t, want, p, test.name)
+		assert.Equal(t, wantOptions, o, test.name)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	flags := flag.NewFlagSet("runsim", flag.ContinueOnError)
+	p, o, err := processFlags(flags, []string{"-agents", "70", "-quantiles", "0.1,0.9", "-gedcomids", "3", "-diploid"})
+	require.NoError(t, err, "Parse flags")
+	var out strings.Builder
+	require.NoError(t, printConfig(&out, flags), "Print configuration")
+	text, found := strings.CutPrefix(out.String(), "Configuration: ")
+	require.True(t, found, "Configuration labelled")
+	var values map[string]any
+	require.NoError(t, json.Unmarshal([]byte(text), &values), "Configuration is JSON")
+	assert.Equal(t, 70.0, values["agents"], "Integer setting")
+	assert.Equal(t, []any{0.1, 0.9}, values["quantiles"], "List setting")
+	assert.Equal(t, []any{3.0}, values["gedcomids"], "Id list setting")
+	assert.Equal(t, true, values["diploid"], "Boolean setting")
+	assert.Equal(t, "NCDG", values["analysis"], "Default setting")
+	assert.NotContains(t, values, "config", "Configuration file not echoed")
+
+	// The echo repeats the run when used as a configuration file
+	path := filepath.Join(t.TempDir(), "echo.json")
+	require.NoError(t, os.WriteFile(path, []byte(text), 0644), "Save configuration")
+	repeated, repeatedOptions, err := processFlags(flag.NewFlagSet("runsim", flag.ContinueOnError),
+		[]string{"-config", path})
+	require.NoError(t, err, "Read echoed configuration")
+	assert.Equal(t, p, repeated, "Same parameters")
+	assert.Equal(t, o, repeatedOptions, "Same options")
+}
@@ -4,6 +4,14 @@ go 1.24.3
 
 replace nathangeffen/abm => ../abm
 
-require nathangeffen/abm v0.0.0-00010101000000-000000000000
+require (
+	github.com/stretchr/testify v1.10.0
+	nathangeffen/abm v0.0.0-00010101000000-000000000000
+)
 
-require golang.org/x/exp v0.0.0-20250531010427-b6e5de432a8b // indirect
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/exp v0.0.0-20250531010427-b6e5de432a8b // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
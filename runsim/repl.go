@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"nathangeffen/abm"
+	"strconv"
+	"strings"
+)
+
+const replHelp = `Commands:
+  agent ID                  Show an agent's sex, generation, parents and children
+  ancestors ID [DEPTH]      List an agent's ancestors, up to DEPTH generations back
+  descendants ID [DEPTH]    List an agent's descendants, up to DEPTH generations forward
+  relationship ID1 ID2      Show how the first agent is related to the second
+  mrca ID1 ID2              Show the most recent common ancestors of two agents
+  help                      Show this help
+  quit                      Leave the shell`
+
+// Parses the integer arguments of a command, which needs at least min and
+// at most max of them
+func replArgs(fields []string, min, max int) ([]int, error) {
+	if len(fields) < min || len(fields) > max {
+		return nil, errors.New("wrong number of arguments, type help for usage")
+	}
+	args := make([]int, len(fields))
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", field)
+		}
+		args[i] = n
+	}
+	return args, nil
+}
+
+// Formats a list of ids on one line
+func formatIds(ids []int) string {
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.Itoa(id)
+	}
+	return strings.Join(fields, " ")
+}
+
+// Formats a count of ids with the singular or plural noun, followed by the
+// ids if there are any
+func formatCount(ids []int, singular, plural string) string {
+	switch len(ids) {
+	case 0:
+		return "0 " + plural
+	case 1:
+		return fmt.Sprintf("1 %s: %s", singular, formatIds(ids))
+	}
+	return fmt.Sprintf("%d %s: %s", len(ids), plural, formatIds(ids))
+}
+
+// Formats a parent's id, which may be unknown
+func formatParent(id int) string {
+	if id == abm.Unknown {
+		return "unknown"
+	}
+	return strconv.Itoa(id)
+}
+
+// Runs one shell command, returning the text to print
+func replCommand(s *abm.Simulation, command string, fields []string) (string, error) {
+	switch command {
+	case "help":
+		return replHelp, nil
+	case "agent":
+		args, err := replArgs(fields, 1, 1)
+		if err != nil {
+			return "", err
+		}
+		info, err := s.Agent(args[0])
+		if err != nil {
+			return "", err
+		}
+		sex := "unknown sex"
+		switch info.Sex {
+		case abm.MALE:
+			sex = "male"
+		case abm.FEMALE:
+			sex = "female"
+		}
+		return fmt.Sprintf("Agent %d: %s, generation %d, mother %s, father %s, %s",
+			info.Id, sex, info.Generation, formatParent(info.Mother), formatParent(info.Father),
+			formatCount(info.Children, "child", "children")), nil
+	case "ancestors", "descendants":
+		args, err := replArgs(fields, 1, 2)
+		if err != nil {
+			return "", err
+		}
+		depth := 0
+		if len(args) == 2 {
+			depth = args[1]
+		}
+		var ids []int
+		if command == "ancestors" {
+			ids, err = s.Ancestors(args[0], depth)
+		} else {
+			ids, err = s.Descendants(args[0], depth)
+		}
+		if err != nil {
+			return "", err
+		}
+		return formatCount(ids, strings.TrimSuffix(command, "s"), command), nil
+	case "relationship", "mrca":
+		args, err := replArgs(fields, 2, 2)
+		if err != nil {
+			return "", err
+		}
+		r, err := s.Relationship(args[0], args[1])
+		if err != nil {
+			return "", err
+		}
+		if len(r.Common) == 0 {
+			return fmt.Sprintf("Agents %d and %d have no common ancestor", args[0], args[1]), nil
+		}
+		generations := "generations"
+		if r.GenerationsA == 1 {
+			generations = "generation"
+		}
+		mrca := fmt.Sprintf("Most recent common ancestors: %s, %d %s back from %d and %d from %d",
+			formatIds(r.Common), r.GenerationsA, generations, args[0], r.GenerationsB, args[1])
+		if command == "mrca" {
+			return mrca, nil
+		}
+		return fmt.Sprintf("Agent %d is agent %d's %s\n%s", args[0], args[1], r.Name, mrca), nil
+	}
+	return "", fmt.Errorf("unknown command %q, type help for usage", command)
+}
+
+// Runs an interactive shell for querying the pedigree of a finished
+// simulation, reading commands from in until quit or end of input
+func repl(s *abm.Simulation, in io.Reader, out io.Writer) {
+	fmt.Fprintf(out, "Querying %d agents. Type help for commands.\n", s.NumAgents())
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		command := strings.ToLower(fields[0])
+		if command == "quit" || command == "exit" {
+			return
+		}
+		text, err := replCommand(s, command, fields[1:])
+		if err != nil {
+			fmt.Fprintln(out, "Error:", err)
+			continue
+		}
+		fmt.Fprintln(out, text)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nathangeffen/abm"
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	// Cat and dan are children of bob, dan's mother is unknown and so is
+	// eve's sex
+	parameters := abm.NewParameters()
+	simulation, err := abm.ReadCSV(strings.NewReader(`id,sex,mother,father
+ann,F,,
+bob,M,,
+cat,F,ann,bob
+dan,M,,bob
+eve,U,cat,dan
+`), &parameters)
+	require.NoError(t, err, "Read CSV pedigree")
+	tests := []struct {
+		name   string
+		input  string
+		output string
+	}{
+		{"Agent", "agent 3\n",
+			"> Agent 3: male, generation 1, mother unknown, father 1, 1 child: 4\n> \n"},
+		{"Childless agent", "agent 4\n",
+			"> Agent 4: unknown sex, generation 2, mother 2, father 3, 0 children\n> \n"},
+		{"Ancestors", "ancestors 4\n", "> 4 ancestors: 0 1 2 3\n> \n"},
+		{"Ancestors to a depth", "ancestors 4 1\n", "> 2 ancestors: 2 3\n> \n"},
+		{"Founder's ancestors", "ancestors 0\n", "> 0 ancestors\n> \n"},
+		{"One ancestor", "ancestors 3\n", "> 1 ancestor: 1\n> \n"},
+		{"One descendant", "descendants 3\n", "> 1 descendant: 4\n> \n"},
+		{"Descendants", "DESCENDANTS 1\n", "> 3 descendants: 2 3 4\n> \n"},
+		{"Relationship", "relationship 2 3\n",
+			"> Agent 2 is agent 3's half or full sister\n" +
+				"Most recent common ancestors: 1, 1 generation back from 2 and 1 from 3\n> \n"},
+		{"Relationship of unknown sex", "relationship 4 2\n",
+			"> Agent 4 is agent 2's child\n" +
+				"Most recent common ancestors: 2, 1 generation back from 4 and 0 from 2\n> \n"},
+		{"MRCA", "mrca 4 1\n",
+			"> Most recent common ancestors: 1, 2 generations back from 4 and 0 from 1\n> \n"},
+		{"Unrelated", "mrca 0 1\n", "> Agents 0 and 1 have no common ancestor\n> \n"},
+		{"Too few arguments", "agent\n", "> Error: wrong number of arguments, type help for usage\n> \n"},
+		{"Too many arguments", "ancestors 1 2 3\n",
+			"> Error: wrong number of arguments, type help for usage\n> \n"},
+		{"One agent in a relationship", "relationship 2\n",
+			"> Error: wrong number of arguments, type help for usage\n> \n"},
+		{"Not a number", "descendants x\n", "> Error: \"x\" is not a number\n> \n"},
+		{"Unknown agent", "agent 5\n", "> Error: no agent with id 5\n> \n"},
+		{"Unknown command", "grow 1\n", "> Error: unknown command \"grow\", type help for usage\n> \n"},
+		{"Help", "help\n", "> " + replHelp + "\n> \n"},
+		{"Blank lines", "\n  \nagent 0\n",
+			"> > > Agent 0: female, generation 0, mother unknown, father unknown, 1 child: 2\n> \n"},
+		{"Quit", "quit\nagent 0\n", "> "},
+		{"Exit", "agent 2\nexit\n",
+			"> Agent 2: female, generation 1, mother 0, father 1, 1 child: 4\n> "},
+		{"End of input without a newline", "agent 4",
+			"> Agent 4: unknown sex, generation 2, mother 2, father 3, 0 children\n> \n"},
+		{"Empty input", "", "> \n"},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		repl(simulation, strings.NewReader(test.input), &out)
+		assert.Equal(t, "Querying 5 agents. Type help for commands.\n"+test.output, out.String(), test.name)
+	}
+}
@@ -2,35 +2,291 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"nathangeffen/abm"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+// Command line options that control what runsim does with a simulation,
+// as opposed to the simulation parameters
+type options struct {
+	// GEDCOM or CSV pedigree file analysed instead of running a simulation
+	importFile string
+	// File to write the diversity time series to
+	diversity string
+	// File to write the pedigree to in GEDCOM format
+	gedcom string
+	// Agents whose ancestry is written to the GEDCOM file, all agents if empty
+	gedcomIds []int
+	// File to write a Graphviz DOT graph of an agent's pedigree to
+	dot string
+	// Agent whose pedigree is drawn, the last agent if negative
+	dotAgent int
+	// Number of generations drawn, all if 0
+	dotDepth int
+	// Either ancestry or descendancy
+	dotMode string
+	// File to write the agent table to, in JSON Lines format if the
+	// extension is .jsonl and CSV otherwise
+	agentsFile string
+	// Generation written to the agent table, all if negative
+	agentsGen int
+	// Start a shell for querying the pedigree after the analysis
+	repl bool
+}
+
 // Process the command line arguments and return values set in
-// parameters struct.
-func processFlags() abm.Parameters {
+// parameters struct and options. The flags are defined in the given flag
+// set.
+func processFlags(flags *flag.FlagSet, args []string) (abm.Parameters, options, error) {
 	params := abm.NewParameters()
 	var p abm.Parameters
-	flag.IntVar(&p.SimulationId, "id", params.SimulationId, "Id of simulation")
-	flag.IntVar(&p.NumAgents, "agents", params.NumAgents, "Number of agents")
-	flag.IntVar(&p.Generations, "generations", params.Generations, "Number of generations to run for")
-	flag.Float64Var(&p.GrowthRate, "growth", params.GrowthRate, "Growth rate of population")
-	flag.BoolVar(&p.Monogamous, "monog", params.Monogamous, "Agents are monogamous")
-	flag.IntVar(&p.MatingK, "matingk", params.MatingK, "Number of agents to search for compatible match")
-	flag.BoolVar(&p.Compatible, "compatible", params.Compatible, "choose compatible agents when mating")
-	flag.IntVar(&p.NumGenes, "genes", params.NumGenes, "Number of genes per agent in initial generation")
-	flag.Float64Var(&p.MutationRate, "mutation", params.MutationRate, "Gene mutation rate")
-	flag.StringVar(&p.Analysis, "analysis", params.Analysis,
+	var o options
+	flags.IntVar(&p.SimulationId, "id", params.SimulationId, "Id of simulation")
+	flags.IntVar(&p.NumAgents, "agents", params.NumAgents, "Number of agents")
+	flags.IntVar(&p.Generations, "generations", params.Generations, "Number of generations to run for")
+	flags.Float64Var(&p.GrowthRate, "growth", params.GrowthRate, "Growth rate of population")
+	flags.BoolVar(&p.Monogamous, "monog", params.Monogamous, "Agents are monogamous")
+	flags.IntVar(&p.MatingK, "matingk", params.MatingK, "Number of agents to search for compatible match")
+	flags.BoolVar(&p.Compatible, "compatible", params.Compatible, "choose compatible agents when mating")
+	flags.IntVar(&p.NumGenes, "genes", params.NumGenes, "Number of genes per agent in initial generation")
+	flags.Float64Var(&p.MutationRate, "mutation", params.MutationRate, "Gene mutation rate")
+	flags.BoolVar(&p.TrackAncestry, "track", params.TrackAncestry, "Compute ancestry for each generation during the simulation")
+	flags.IntVar(&p.Workers, "workers", params.Workers, "Number of workers for pairwise analyses (0 for one per CPU)")
+	flags.IntVar(&p.PairSample, "pairsample", params.PairSample, "Number of random pairs used to estimate pairwise statistics (0 for all pairs)")
+	flags.IntVar(&p.SimplifyInterval, "simplify", params.SimplifyInterval, "Remove agents without descendants every this many generations (0 for never)")
+	flags.BoolVar(&p.Diploid, "diploid", params.Diploid, "Agents carry two copies of each gene")
+	flags.IntVar(&p.Chromosomes, "chromosomes", params.Chromosomes, "Number of chromosomes the genes are spread across (0 for unlinked genes)")
+	flags.Float64Var(&p.ChromosomeLength, "chromlength", params.ChromosomeLength, "Genetic map length of each chromosome in Morgans")
+	flags.Float64Var(&p.UniparentalMutationRate, "umutation", params.UniparentalMutationRate, "Y chromosome and mitochondrial mutation rate")
+	flags.StringVar(&p.Surnames, "surnames", params.Surnames, "Parent surnames are inherited from: father or mother (empty for no surnames)")
+	flags.StringVar(&p.MutationModel, "mutationmodel", params.MutationModel, "Mutation model: infinite-alleles, infinite-sites or stepwise")
+	flags.Var(floatList{&p.LocusMutationRates}, "mutationrates", "Comma separated mutation rates of the first loci, overriding -mutation")
+	flags.Float64Var(&p.VariantFrequency, "variant", params.VariantFrequency, "Proportion of founder genes carrying the focal variant")
+	flags.IntVar(&p.SelectedLoci, "selectedloci", params.SelectedLoci, "Number of loci where the variant affects fitness")
+	flags.Float64Var(&p.SelectionCoefficient, "selection", params.SelectionCoefficient, "Change in fitness of agents homozygous for the variant at a selected locus")
+	flags.StringVar(&p.Dominance, "dominance", params.Dominance, "Dominance of the variant: additive, dominant or recessive")
+	flags.Float64Var(&p.IBDMinLength, "ibdmin", params.IBDMinLength, "Shortest segment in Morgans counted as shared identical by descent")
+	p.Quantiles = params.Quantiles
+	flags.Var(floatList{&p.Quantiles}, "quantiles", "Comma separated quantiles reported for ancestor statistics")
+	flags.IntVar(&p.HistogramBins, "bins", params.HistogramBins, "Number of histogram bins reported for ancestor statistics")
+	flags.StringVar(&p.Analysis, "analysis", params.Analysis,
 		`N - Number of ancestors
 C - Number of common ancestors
 D - Generation differences
-G - Gene analysis`)
-	flag.Parse()
-	return p
+G - Gene analysis
+S - Chromosome segment analysis
+A - Genetic versus genealogical ancestors
+U - Y chromosome and mitochondrial lineages
+F - Surname survival
+M - Mutations
+T - Variant frequency trajectories under selection and drift
+H - Genetic diversity time series
+I - Identity by descent segment sharing`)
+	flags.StringVar(&o.diversity, "diversity", "", "Write the genetic diversity time series as CSV to this file")
+	flags.StringVar(&o.gedcom, "gedcom", "", "Write the pedigree to this file in GEDCOM format")
+	flags.Var(intList{&o.gedcomIds}, "gedcomids", "Comma separated ids of agents whose ancestry is written to the GEDCOM file (default all agents)")
+	flags.StringVar(&o.importFile, "import", "", "Analyse the pedigree in this GEDCOM (.ged) or CSV file instead of simulating")
+	flags.StringVar(&o.dot, "dot", "", "Write a Graphviz DOT graph of an agent's pedigree to this file")
+	flags.IntVar(&o.dotAgent, "dotagent", -1, "Agent whose pedigree is drawn (default the last agent)")
+	flags.IntVar(&o.dotDepth, "dotdepth", 0, "Number of generations drawn in the DOT graph (0 for all)")
+	flags.StringVar(&o.dotMode, "dotmode", "ancestry", "Draw the agent's ancestry or descendancy")
+	flags.StringVar(&o.agentsFile, "agentsout", "", "Write the agent table to this CSV or JSON Lines (.jsonl) file")
+	flags.IntVar(&o.agentsGen, "agentsgen", -1, "Generation written to the agent table (default all)")
+	flags.BoolVar(&o.repl, "repl", false, "Query the pedigree in an interactive shell after the analysis (use with -import to query a saved pedigree)")
+	config := flags.String("config", "", "Read settings named after these flags from a JSON (.json) or flat YAML file; flags given on the command line take precedence")
+	if err := flags.Parse(args); err != nil {
+		return p, o, err
+	}
+	if *config != "" {
+		if err := applyConfig(flags, *config); err != nil {
+			return p, o, fmt.Errorf("reading configuration file: %w", err)
+		}
+	}
+	return p, o, nil
+}
+
+// Parses a comma separated list of numbers
+func parseFloats(value string) ([]float64, error) {
+	var result []float64
+	for _, field := range strings.Split(value, ",") {
+		number, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, number)
+	}
+	return result, nil
+}
+
+// Parses a comma separated list of integers
+func parseInts(value string) ([]int, error) {
+	var result []int
+	for _, field := range strings.Split(value, ",") {
+		number, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, number)
+	}
+	return result, nil
+}
+
+// Reads a pedigree from a GEDCOM file if its extension is .ged or .gedcom
+// and from a CSV file otherwise
+func importPedigree(path string, parameters *abm.Parameters) (*abm.Simulation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ged", ".gedcom":
+		return abm.ReadGEDCOM(f, parameters)
+	}
+	return abm.ReadCSV(f, parameters)
+}
+
+// Writes a file with the write function. A regular file is written to a
+// temporary file in the same directory that replaces the file only if the
+// write succeeds, so a failed export leaves any existing file untouched.
+// The replacement keeps the existing file's permissions, and new files are
+// readable by everyone. Other targets, such as /dev/stdout and pipes, and
+// files in directories where a temporary file can't be created are
+// written directly.
+func writeFile(path string, write func(w io.Writer) error) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		if !info.Mode().IsRegular() {
+			return writeDirect(path, write)
+		}
+		mode = info.Mode().Perm()
+		// Replace the file a symbolic link points to rather than the link
+		if target, err := filepath.EvalSymlinks(path); err == nil {
+			path = target
+		}
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return writeDirect(path, write)
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Writes a file in place with the write function
+func writeDirect(path string, write func(w io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 func main() {
-	parameters := processFlags()
-	simulation := abm.NewSimulation(&parameters)
-	simulation.Simulate()
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+	parameters, opts, err := processFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if err := printConfig(os.Stdout, flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, "Error printing configuration:", err)
+		os.Exit(1)
+	}
+	validate := parameters.Validate
+	if opts.importFile != "" {
+		validate = parameters.ValidateAnalysis
+	}
+	if err := validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid parameters:\n%v\n", err)
+		os.Exit(1)
+	}
+	var simulation *abm.Simulation
+	if opts.importFile != "" {
+		simulation, err = importPedigree(opts.importFile, &parameters)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error importing pedigree:", err)
+			os.Exit(1)
+		}
+	} else {
+		simulation, err = abm.NewSimulation(&parameters)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating simulation:", err)
+			os.Exit(1)
+		}
+		simulation.Simulate()
+	}
 	simulation.Analysis()
+	if opts.diversity != "" {
+		if err := writeFile(opts.diversity, simulation.WriteDiversity); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing diversity time series:", err)
+			os.Exit(1)
+		}
+	}
+	if opts.gedcom != "" {
+		err := writeFile(opts.gedcom, func(w io.Writer) error {
+			return simulation.WriteGEDCOM(w, opts.gedcomIds)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing GEDCOM file:", err)
+			os.Exit(1)
+		}
+	}
+	if opts.dot != "" {
+		agent := opts.dotAgent
+		if agent < 0 {
+			agent = simulation.NumAgents() - 1
+		}
+		err := writeFile(opts.dot, func(w io.Writer) error {
+			switch opts.dotMode {
+			case "ancestry":
+				return simulation.WriteAncestryDOT(w, agent, opts.dotDepth)
+			case "descendancy":
+				return simulation.WriteDescendancyDOT(w, agent, opts.dotDepth)
+			}
+			return fmt.Errorf("unknown DOT mode %q", opts.dotMode)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing DOT file:", err)
+			os.Exit(1)
+		}
+	}
+	if opts.agentsFile != "" {
+		err := writeFile(opts.agentsFile, func(w io.Writer) error {
+			if strings.ToLower(filepath.Ext(opts.agentsFile)) == ".jsonl" {
+				return simulation.WriteAgentsJSONL(w, opts.agentsGen)
+			}
+			return simulation.WriteAgentsCSV(w, opts.agentsGen)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing agent table:", err)
+			os.Exit(1)
+		}
+	}
+	if opts.repl {
+		repl(simulation, os.Stdin, os.Stdout)
+	}
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.csv")
+	require.NoError(t, os.WriteFile(existing, []byte("old"), 0600), "Create existing file")
+	link := filepath.Join(dir, "link.csv")
+	target := filepath.Join(dir, "target.csv")
+	require.NoError(t, os.WriteFile(target, []byte("old"), 0640), "Create link target")
+	require.NoError(t, os.Symlink(target, link), "Create link")
+	readOnly := filepath.Join(dir, "readonly")
+	require.NoError(t, os.Mkdir(readOnly, 0755), "Create directory")
+	inReadOnly := filepath.Join(readOnly, "file.csv")
+	require.NoError(t, os.WriteFile(inReadOnly, []byte("old"), 0644), "Create file in directory")
+	require.NoError(t, os.Chmod(readOnly, 0555), "Make directory read only")
+	t.Cleanup(func() { os.Chmod(readOnly, 0755) })
+
+	tests := []struct {
+		name string
+		path string
+		// File whose contents and mode are checked, the path if empty
+		file string
+		mode os.FileMode
+	}{
+		{"New file", filepath.Join(dir, "new.csv"), "", 0644},
+		{"Existing file keeps its mode", existing, "", 0600},
+		{"Linked file", link, target, 0640},
+		{"Directory where files can't be created", inReadOnly, "", 0644},
+	}
+	for _, test := range tests {
+		require.NoError(t, writeFile(test.path, func(w io.Writer) error {
+			_, err := io.WriteString(w, "new")
+			return err
+		}), test.name)
+		file := test.file
+		if file == "" {
+			file = test.path
+		}
+		data, err := os.ReadFile(file)
+		require.NoError(t, err, test.name)
+		assert.Equal(t, "new", string(data), test.name)
+		info, err := os.Stat(file)
+		require.NoError(t, err, test.name)
+		assert.Equal(t, test.mode, info.Mode().Perm(), test.name)
+	}
+	info, err := os.Lstat(link)
+	require.NoError(t, err, "Stat link")
+	assert.Equal(t, os.ModeSymlink, info.Mode().Type(), "Link kept")
+
+	failed := errors.New("failed")
+	assert.Equal(t, failed, writeFile(existing, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return failed
+	}), "Write error returned")
+	data, err := os.ReadFile(existing)
+	require.NoError(t, err, "Read existing file")
+	assert.Equal(t, "new", string(data), "Failed write leaves the file untouched")
+
+	require.NoError(t, writeFile(os.DevNull, func(w io.Writer) error {
+		_, err := io.WriteString(w, "discarded")
+		return err
+	}), "Write to a device")
+	info, err = os.Stat(os.DevNull)
+	require.NoError(t, err, "Stat device")
+	assert.False(t, info.Mode().IsRegular(), "Device not replaced by a file")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err, "List directory")
+	assert.Len(t, entries, 5, "No temporary files left")
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"nathangeffen/abm"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// States of a simulation job
+const (
+	QUEUED  = "queued"
+	RUNNING = "running"
+	DONE    = "done"
+	FAILED  = "failed"
+)
+
+// A simulation run by the server. The simulation, report and results are
+// only set once the job is done. The log holds problems the simulation
+// ran into and is set once the job finishes. The mutex serialises exports, some of
+// which update the simulation's ancestry.
+type job struct {
+	mu         sync.Mutex
+	id         int
+	parameters abm.Parameters
+	status     string
+	err        string
+	created    time.Time
+	started    time.Time
+	finished   time.Time
+	simulation *abm.Simulation
+	report     []byte
+	log        string
+	results    abm.Results
+}
+
+// The status of a job returned to clients
+type jobStatus struct {
+	Id         int            `json:"id"`
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	Log        string         `json:"log,omitempty"`
+	Created    time.Time      `json:"created"`
+	Started    *time.Time     `json:"started,omitempty"`
+	Finished   *time.Time     `json:"finished,omitempty"`
+	Parameters abm.Parameters `json:"parameters"`
+}
+
+// Holds the jobs submitted to the server and the queue workers take them
+// from. Finished jobs are kept in the order they finished so that the
+// oldest can be deleted first.
+type server struct {
+	mu     sync.Mutex
+	jobs   map[int]*job
+	nextId int
+	queue  chan *job
+	done   []*job
+	keep   int
+	ttl    time.Duration
+	limits limits
+}
+
+// The largest simulations the server accepts, 0 for no limit. PairSample
+// limits the pairs sampled by pairwise analyses and HistogramBins the
+// bins of ancestor statistics.
+type limits struct {
+	NumAgents     int
+	Generations   int
+	NumGenes      int
+	PairSample    int
+	HistogramBins int
+}
+
+// Returns an error describing every parameter that exceeds the limits, or
+// nil if none do
+func (l limits) check(p *abm.Parameters) error {
+	var errs []error
+	check := func(name string, value, limit int) {
+		if limit > 0 && value > limit {
+			errs = append(errs, fmt.Errorf("%s can't be more than %d on this server, not %d", name, limit, value))
+		}
+	}
+	check("NumAgents", p.NumAgents, l.NumAgents)
+	check("Generations", p.Generations, l.Generations)
+	check("NumGenes", p.NumGenes, l.NumGenes)
+	check("PairSample", p.PairSample, l.PairSample)
+	check("HistogramBins", p.HistogramBins, l.HistogramBins)
+	return errors.Join(errs...)
+}
+
+// Creates a server with the given number of workers running jobs and room
+// for queueSize jobs waiting to run. At most keep finished jobs are kept,
+// each for at most ttl, where 0 means no limit. Jobs exceeding the limits
+// are rejected.
+func newServer(workers, queueSize, keep int, ttl time.Duration, limits limits) *server {
+	srv := &server{
+		jobs:   make(map[int]*job),
+		nextId: 1,
+		queue:  make(chan *job, queueSize),
+		keep:   keep,
+		ttl:    ttl,
+		limits: limits,
+	}
+	for range workers {
+		go srv.work()
+	}
+	return srv
+}
+
+// Runs jobs from the queue until it is closed
+func (srv *server) work() {
+	for j := range srv.queue {
+		srv.run(j)
+	}
+}
+
+// Runs the simulation and analyses of a job, marking it failed if the
+// simulation can't be created or panics. Jobs deleted while queued aren't
+// run.
+func (srv *server) run(j *job) {
+	srv.mu.Lock()
+	if srv.jobs[j.id] != j {
+		srv.mu.Unlock()
+		return
+	}
+	j.status = RUNNING
+	j.started = time.Now()
+	srv.mu.Unlock()
+	var simulation *abm.Simulation
+	var report, errLog bytes.Buffer
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("simulation failed: %v", r)
+			}
+		}()
+		simulation, err = abm.NewSimulation(&j.parameters)
+		if err != nil {
+			return err
+		}
+		simulation.SetOutput(&report)
+		simulation.SetErrorOutput(&errLog)
+		simulation.Simulate()
+		simulation.Analysis()
+		return nil
+	}()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	j.finished = time.Now()
+	j.log = errLog.String()
+	if srv.jobs[j.id] == j {
+		srv.done = append(srv.done, j)
+		srv.pruneLocked()
+	}
+	if err != nil {
+		j.status = FAILED
+		j.err = err.Error()
+		return
+	}
+	j.status = DONE
+	j.simulation = simulation
+	j.report = report.Bytes()
+	j.results = simulation.Results()
+}
+
+// Deletes the oldest finished jobs beyond the number kept and those that
+// finished longer ago than the time to live. The server mutex must be
+// held.
+func (srv *server) pruneLocked() {
+	now := time.Now()
+	for len(srv.done) > 0 {
+		oldest := srv.done[0]
+		expired := srv.ttl > 0 && now.Sub(oldest.finished) >= srv.ttl
+		if !expired && (srv.keep == 0 || len(srv.done) <= srv.keep) {
+			break
+		}
+		delete(srv.jobs, oldest.id)
+		srv.done = srv.done[1:]
+	}
+}
+
+// Returns a job's status. The server mutex must be held.
+func (j *job) statusLocked() jobStatus {
+	status := jobStatus{
+		Id:         j.id,
+		Status:     j.status,
+		Error:      j.err,
+		Log:        j.log,
+		Created:    j.created,
+		Parameters: j.parameters,
+	}
+	if !j.started.IsZero() {
+		status.Started = &j.started
+	}
+	if !j.finished.IsZero() {
+		status.Finished = &j.finished
+	}
+	return status
+}
+
+// Writes a value as a JSON response
+func writeJSON(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Writes an error as a JSON response
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// Creates a job from parameters posted as a JSON object with the field
+// names of abm.Parameters. Missing fields keep their default values.
+func (srv *server) create(w http.ResponseWriter, r *http.Request) {
+	parameters := abm.NewParameters()
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&parameters); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := errors.Join(parameters.Validate(), srv.limits.check(&parameters)); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.pruneLocked()
+	j := &job{
+		id:         srv.nextId,
+		parameters: parameters,
+		status:     QUEUED,
+		created:    time.Now(),
+	}
+	select {
+	case srv.queue <- j:
+	default:
+		writeError(w, http.StatusServiceUnavailable, errors.New("too many queued simulations"))
+		return
+	}
+	srv.nextId++
+	srv.jobs[j.id] = j
+	w.Header().Set("Location", fmt.Sprintf("/simulations/%d", j.id))
+	writeJSON(w, http.StatusAccepted, j.statusLocked())
+}
+
+// Lists the status of every job
+func (srv *server) list(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	srv.pruneLocked()
+	statuses := make([]jobStatus, 0, len(srv.jobs))
+	for id := 1; id < srv.nextId; id++ {
+		if j, found := srv.jobs[id]; found {
+			statuses = append(statuses, j.statusLocked())
+		}
+	}
+	srv.mu.Unlock()
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// Returns the job named in the request path, writing an error response
+// and returning nil if there isn't one
+func (srv *server) lookup(w http.ResponseWriter, r *http.Request) *job {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err == nil {
+		srv.mu.Lock()
+		srv.pruneLocked()
+		j, found := srv.jobs[id]
+		srv.mu.Unlock()
+		if found {
+			return j
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("no simulation %s", r.PathValue("id")))
+	return nil
+}
+
+// Returns the status of a job
+func (srv *server) status(w http.ResponseWriter, r *http.Request) {
+	if j := srv.lookup(w, r); j != nil {
+		srv.mu.Lock()
+		status := j.statusLocked()
+		srv.mu.Unlock()
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// Deletes a job. A queued job is never run, and a running job finishes but
+// its results are discarded.
+func (srv *server) remove(w http.ResponseWriter, r *http.Request) {
+	if j := srv.lookup(w, r); j != nil {
+		srv.mu.Lock()
+		delete(srv.jobs, j.id)
+		srv.done = slices.DeleteFunc(srv.done, func(d *job) bool { return d == j })
+		srv.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Returns a finished job with its mutex held, writing an error response
+// and returning nil if the job doesn't exist or isn't done. The caller
+// must unlock the job.
+func (srv *server) finished(w http.ResponseWriter, r *http.Request) *job {
+	j := srv.lookup(w, r)
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	if j.simulation == nil {
+		j.mu.Unlock()
+		srv.mu.Lock()
+		status := j.status
+		srv.mu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("simulation %d is %s", j.id, status))
+		return nil
+	}
+	return j
+}
+
+// Returns the structured results of a finished job
+func (srv *server) results(w http.ResponseWriter, r *http.Request) {
+	if j := srv.finished(w, r); j != nil {
+		defer j.mu.Unlock()
+		writeJSON(w, http.StatusOK, j.results)
+	}
+}
+
+// Returns the text report of a finished job
+func (srv *server) report(w http.ResponseWriter, r *http.Request) {
+	if j := srv.finished(w, r); j != nil {
+		defer j.mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(j.report)
+	}
+}
+
+// Returns an integer query parameter, or the default if it isn't given
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// Returns a handler that writes an export of a finished job. The export
+// is written to a buffer first so that errors can still be reported.
+func (srv *server) export(contentType string, write func(s *abm.Simulation, r *http.Request, w io.Writer) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j := srv.finished(w, r)
+		if j == nil {
+			return
+		}
+		defer j.mu.Unlock()
+		var buf bytes.Buffer
+		if err := write(j.simulation, r, &buf); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(buf.Bytes())
+	}
+}
+
+// Writes the diversity time series
+func exportDiversity(s *abm.Simulation, r *http.Request, w io.Writer) error {
+	return s.WriteDiversity(w)
+}
+
+// Writes the pedigree as GEDCOM, limited to the ancestry of the agents in
+// the ids query parameter if given
+func exportGEDCOM(s *abm.Simulation, r *http.Request, w io.Writer) error {
+	var ids []int
+	if value := r.URL.Query().Get("ids"); value != "" {
+		var err error
+		if ids, err = parseInts(value); err != nil {
+			return err
+		}
+	}
+	return s.WriteGEDCOM(w, ids)
+}
+
+// Writes a DOT graph of the agent, depth and mode query parameters
+func exportDOT(s *abm.Simulation, r *http.Request, w io.Writer) error {
+	agent, err := queryInt(r, "agent", s.NumAgents()-1)
+	if err != nil {
+		return err
+	}
+	depth, err := queryInt(r, "depth", 0)
+	if err != nil {
+		return err
+	}
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "ancestry":
+		return s.WriteAncestryDOT(w, agent, depth)
+	case "descendancy":
+		return s.WriteDescendancyDOT(w, agent, depth)
+	default:
+		return fmt.Errorf("unknown DOT mode %q", mode)
+	}
+}
+
+// Writes the agent table as CSV, or as JSON Lines if the format query
+// parameter is jsonl, for the generation query parameter if given
+func exportAgents(s *abm.Simulation, r *http.Request, w io.Writer) error {
+	generation, err := queryInt(r, "generation", -1)
+	if err != nil {
+		return err
+	}
+	if r.URL.Query().Get("format") == "jsonl" {
+		return s.WriteAgentsJSONL(w, generation)
+	}
+	return s.WriteAgentsCSV(w, generation)
+}
+
+// Returns the routes of the REST API
+func (srv *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /simulations", srv.create)
+	mux.HandleFunc("GET /simulations", srv.list)
+	mux.HandleFunc("GET /simulations/{id}", srv.status)
+	mux.HandleFunc("DELETE /simulations/{id}", srv.remove)
+	mux.HandleFunc("GET /simulations/{id}/results", srv.results)
+	mux.HandleFunc("GET /simulations/{id}/report", srv.report)
+	mux.HandleFunc("GET /simulations/{id}/diversity", srv.export("text/csv", exportDiversity))
+	mux.HandleFunc("GET /simulations/{id}/gedcom", srv.export("text/plain; charset=utf-8", exportGEDCOM))
+	mux.HandleFunc("GET /simulations/{id}/dot", srv.export("text/vnd.graphviz", exportDOT))
+	mux.HandleFunc("GET /simulations/{id}/agents", srv.export("text/plain; charset=utf-8", exportAgents))
+	return mux
+}
+
+// Runs runsim as an HTTP server exposing simulations as a REST API:
+//
+//	POST /simulations                 create a job from JSON parameters
+//	GET  /simulations                 list jobs
+//	GET  /simulations/{id}            job status
+//	DELETE /simulations/{id}          delete a job
+//	GET  /simulations/{id}/results    structured analysis results
+//	GET  /simulations/{id}/report     text analysis report
+//	GET  /simulations/{id}/diversity  diversity time series CSV
+//	GET  /simulations/{id}/gedcom     GEDCOM pedigree (?ids=)
+//	GET  /simulations/{id}/dot        DOT graph (?agent=&depth=&mode=)
+//	GET  /simulations/{id}/agents     agent table (?format=jsonl&generation=)
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "Address to listen on")
+	workers := flags.Int("workers", runtime.NumCPU(), "Number of simulations run at the same time")
+	queueSize := flags.Int("queue", 100, "Number of simulations that can wait to run")
+	keep := flags.Int("keep", 100, "Number of finished simulations kept, deleting the oldest first (0 for no limit)")
+	ttl := flags.Duration("ttl", 24*time.Hour, "How long finished simulations are kept (0 for no limit)")
+	var maximum limits
+	flags.IntVar(&maximum.NumAgents, "maxagents", 100000, "Largest number of agents a simulation can start with (0 for no limit)")
+	flags.IntVar(&maximum.Generations, "maxgenerations", 1000, "Largest number of generations a simulation can run for (0 for no limit)")
+	flags.IntVar(&maximum.NumGenes, "maxgenes", 10000, "Largest number of genes per agent (0 for no limit)")
+	flags.IntVar(&maximum.PairSample, "maxpairs", 1000000, "Largest number of pairs sampled by pairwise analyses (0 for no limit)")
+	flags.IntVar(&maximum.HistogramBins, "maxbins", 1000, "Largest number of histogram bins (0 for no limit)")
+	flags.Parse(args)
+	if *workers < 1 || *queueSize < 0 || *keep < 0 || *ttl < 0 {
+		fmt.Fprintln(os.Stderr, "Error: workers must be positive and queue, keep and ttl can't be negative")
+		os.Exit(1)
+	}
+	if maximum.NumAgents < 0 || maximum.Generations < 0 || maximum.NumGenes < 0 || maximum.PairSample < 0 || maximum.HistogramBins < 0 {
+		fmt.Fprintln(os.Stderr, "Error: limits can't be negative")
+		os.Exit(1)
+	}
+	srv := newServer(*workers, *queueSize, *keep, *ttl, maximum)
+	log.Printf("Serving simulations on http://%s with %d workers", *addr, *workers)
+	log.Fatal(http.ListenAndServe(*addr, srv.handler()))
+}
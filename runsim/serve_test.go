@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"nathangeffen/abm"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a test server, stopping its workers when the test ends
+func startServer(t *testing.T, workers, queueSize, keep int, ttl time.Duration, limits limits) *httptest.Server {
+	srv := newServer(workers, queueSize, keep, ttl, limits)
+	ts := httptest.NewServer(srv.handler())
+	t.Cleanup(func() {
+		ts.Close()
+		close(srv.queue)
+	})
+	return ts
+}
+
+// Sends a request and returns the response status and body
+func request(t *testing.T, method, url, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err, "Create request")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "Send request")
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err, "Read response")
+	return resp.StatusCode, string(data)
+}
+
+// Creates a job and returns its URL
+func createJob(t *testing.T, ts *httptest.Server, body string) string {
+	resp, err := http.Post(ts.URL+"/simulations", "application/json", strings.NewReader(body))
+	require.NoError(t, err, "Post parameters")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, "Job accepted")
+	return ts.URL + resp.Header.Get("Location")
+}
+
+// Polls a job until it finishes and returns its status
+func waitForJob(t *testing.T, url string) jobStatus {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		code, body := request(t, http.MethodGet, url, "")
+		require.Equal(t, http.StatusOK, code, "Job status")
+		var status jobStatus
+		require.NoError(t, json.Unmarshal([]byte(body), &status), "Decode job status")
+		if status.Status == DONE || status.Status == FAILED {
+			return status
+		}
+		require.True(t, time.Now().Before(deadline), "Job finishes")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeSimulation(t *testing.T) {
+	ts := startServer(t, 2, 10, 0, 0, limits{})
+	url := createJob(t, ts, `{"NumAgents": 30, "Generations": 3, "Analysis": "NCDH"}`)
+	status := waitForJob(t, url)
+	require.Equal(t, DONE, status.Status, "Job done")
+	assert.Equal(t, 30, status.Parameters.NumAgents, "Posted parameters used")
+	assert.Equal(t, 10, status.Parameters.NumGenes, "Other parameters have defaults")
+
+	code, body := request(t, http.MethodGet, url+"/results", "")
+	require.Equal(t, http.StatusOK, code, "Results")
+	var results abm.Results
+	require.NoError(t, json.Unmarshal([]byte(body), &results), "Decode results")
+	require.NotNil(t, results.NumAncestors, "Number of ancestors analysed")
+	assert.Positive(t, results.NumAncestors.Count, "Agents in last generation")
+
+	code, body = request(t, http.MethodGet, url+"/report", "")
+	assert.Equal(t, http.StatusOK, code, "Report")
+	assert.Contains(t, body, "Min, max, mean number of ancestors", "Report of the analyses")
+
+	exports := []struct {
+		path     string
+		code     int
+		contains string
+	}{
+		{"/diversity", http.StatusOK, "generation,"},
+		{"/gedcom", http.StatusOK, "0 HEAD"},
+		{"/gedcom?ids=0", http.StatusOK, "0 TRLR"},
+		{"/gedcom?ids=x", http.StatusBadRequest, "error"},
+		{"/dot?agent=0&mode=descendancy", http.StatusOK, "digraph pedigree {"},
+		{"/dot?mode=sideways", http.StatusBadRequest, "unknown DOT mode"},
+		{"/agents?generation=0", http.StatusOK, "id,generation,sex"},
+		{"/agents?format=jsonl&generation=0", http.StatusOK, `{"id":0,`},
+		{"/agents?generation=99", http.StatusBadRequest, "no generation 99"},
+	}
+	for _, export := range exports {
+		code, body := request(t, http.MethodGet, url+export.path, "")
+		assert.Equal(t, export.code, code, export.path)
+		assert.Contains(t, body, export.contains, export.path)
+	}
+
+	assert.Empty(t, status.Log, "Nothing logged for a normal run")
+	failing := waitForJob(t, createJob(t, ts, `{"NumAgents": 1, "Generations": 2}`))
+	assert.Contains(t, failing.Log, "Only one survivor in generation 0", "Simulation problems logged")
+	_, body = request(t, http.MethodGet, ts.URL+"/simulations/2/report", "")
+	assert.NotContains(t, body, "Only one survivor", "Simulation problems kept out of the report")
+
+	code, body = request(t, http.MethodGet, ts.URL+"/simulations", "")
+	assert.Equal(t, http.StatusOK, code, "List jobs")
+	var statuses []jobStatus
+	require.NoError(t, json.Unmarshal([]byte(body), &statuses), "Decode job list")
+	assert.Len(t, statuses, 2, "Every job listed")
+
+	code, _ = request(t, http.MethodDelete, url, "")
+	assert.Equal(t, http.StatusNoContent, code, "Delete job")
+	code, _ = request(t, http.MethodGet, url, "")
+	assert.Equal(t, http.StatusNotFound, code, "Deleted job is gone")
+	code, _ = request(t, http.MethodDelete, url, "")
+	assert.Equal(t, http.StatusNotFound, code, "Deleted job can't be deleted again")
+}
+
+func TestServeErrors(t *testing.T) {
+	// Without workers jobs stay queued
+	ts := startServer(t, 0, 1, 0, 0, limits{NumAgents: 1000, Generations: 10, NumGenes: 100, PairSample: 500, HistogramBins: 20})
+	url := createJob(t, ts, "")
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"Invalid parameters", http.MethodPost, "/simulations", `{"NumAgents": -1}`, http.StatusBadRequest},
+		{"Unknown parameter", http.MethodPost, "/simulations", `{"Agents": 10}`, http.StatusBadRequest},
+		{"Malformed JSON", http.MethodPost, "/simulations", `{"NumAgents":`, http.StatusBadRequest},
+		{"Too many agents", http.MethodPost, "/simulations", `{"NumAgents": 1001}`, http.StatusBadRequest},
+		{"Too many generations", http.MethodPost, "/simulations", `{"Generations": 11}`, http.StatusBadRequest},
+		{"Too many genes", http.MethodPost, "/simulations", `{"NumGenes": 101}`, http.StatusBadRequest},
+		{"Too many pairs", http.MethodPost, "/simulations", `{"PairSample": 501}`, http.StatusBadRequest},
+		{"Too many bins", http.MethodPost, "/simulations", `{"HistogramBins": 21}`, http.StatusBadRequest},
+		{"Full queue", http.MethodPost, "/simulations", `{}`, http.StatusServiceUnavailable},
+		{"Unknown id", http.MethodGet, "/simulations/99", "", http.StatusNotFound},
+		{"Invalid id", http.MethodGet, "/simulations/x/results", "", http.StatusNotFound},
+		{"Unfinished results", http.MethodGet, "/simulations/1/results", "", http.StatusConflict},
+		{"Unfinished report", http.MethodGet, "/simulations/1/report", "", http.StatusConflict},
+		{"Unfinished export", http.MethodGet, "/simulations/1/gedcom", "", http.StatusConflict},
+		{"Queued status", http.MethodGet, "/simulations/1", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		code, body := request(t, test.method, ts.URL+test.path, test.body)
+		assert.Equal(t, test.code, code, test.name)
+		if test.code != http.StatusOK {
+			assert.Contains(t, body, `"error"`, test.name)
+		}
+	}
+
+	_, body := request(t, http.MethodPost, ts.URL+"/simulations", `{"NumAgents": 2000, "Generations": 20}`)
+	assert.Contains(t, body, "NumAgents can't be more than 1000 on this server, not 2000", "Agent limit named")
+	assert.Contains(t, body, "Generations can't be more than 10 on this server, not 20", "Every limit exceeded named")
+
+	code, _ := request(t, http.MethodDelete, url, "")
+	assert.Equal(t, http.StatusNoContent, code, "Delete queued job")
+	code, _ = request(t, http.MethodGet, url, "")
+	assert.Equal(t, http.StatusNotFound, code, "Deleted queued job is gone")
+}
+
+func TestServeRetention(t *testing.T) {
+	ts := startServer(t, 1, 10, 1, 0, limits{})
+	first := createJob(t, ts, `{"NumAgents": 20, "Generations": 2}`)
+	second := createJob(t, ts, `{"NumAgents": 20, "Generations": 2}`)
+	assert.Equal(t, DONE, waitForJob(t, second).Status, "Second job done")
+	code, _ := request(t, http.MethodGet, first, "")
+	assert.Equal(t, http.StatusNotFound, code, "Oldest finished job deleted")
+
+	ts = startServer(t, 1, 10, 0, time.Nanosecond, limits{})
+	url := createJob(t, ts, `{"NumAgents": 20, "Generations": 2}`)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		code, _ := request(t, http.MethodGet, url, "")
+		if code == http.StatusNotFound {
+			break
+		}
+		require.Equal(t, http.StatusOK, code, "Job exists until it expires")
+		require.True(t, time.Now().Before(deadline), "Finished job expires")
+		time.Sleep(10 * time.Millisecond)
+	}
+}